@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// binaryBackup is a previous binary kept by update, restorable by rollback
+type binaryBackup struct {
+	Version    string    `json:"version"`
+	File       string    `json:"file"`
+	BackedUpAt time.Time `json:"backed_up_at"`
+}
+
+// backupDir keeps previous binaries next to the installed one, so they are on the same filesystem
+// and can be swapped back by rename
+func backupDir(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".backups")
+}
+
+func backupIndexPath(target string) string {
+	return filepath.Join(backupDir(target), "index.json")
+}
+
+// readBackups returns backups of the binary at target, newest first
+func readBackups(target string) ([]binaryBackup, error) {
+	b, err := os.ReadFile(backupIndexPath(target))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup index failed: %w", err)
+	}
+	var backups []binaryBackup
+	if err := json.Unmarshal(b, &backups); err != nil {
+		return nil, fmt.Errorf("decode backup index failed: %w", err)
+	}
+	return backups, nil
+}
+
+func writeBackups(target string, backups []binaryBackup) error {
+	b, err := json.MarshalIndent(backups, "", "  ")
+	if err != nil {
+		return err
+	}
+	index := backupIndexPath(target)
+	tmp := index + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("write backup index failed: %w", err)
+	}
+	if err := os.Rename(tmp, index); err != nil {
+		return fmt.Errorf("write backup index failed: %w", err)
+	}
+	return nil
+}
+
+// keepBackup moves the binary at oldPath into the backup dir of target as version, and prunes
+// backups beyond keep. With keep <= 0 the binary is just removed
+func keepBackup(target, oldPath, version string, keep int) error {
+	if keep <= 0 {
+		return os.Remove(oldPath)
+	}
+	if err := os.MkdirAll(backupDir(target), 0755); err != nil {
+		return fmt.Errorf("create backup dir failed: %w", err)
+	}
+	backups, err := readBackups(target)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	backup := binaryBackup{
+		Version:    version,
+		File:       fmt.Sprintf("%s_%s_%d", filepath.Base(target), version, now.Unix()),
+		BackedUpAt: now,
+	}
+	if err := os.Rename(oldPath, filepath.Join(backupDir(target), backup.File)); err != nil {
+		return fmt.Errorf("move %s to backup dir failed: %w", oldPath, err)
+	}
+	backups = append([]binaryBackup{backup}, backups...)
+	if len(backups) > keep {
+		for _, pruned := range backups[keep:] {
+			if err := os.Remove(filepath.Join(backupDir(target), pruned.File)); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "WARN: remove backup %s failed: %v\n", pruned.File, err)
+			}
+		}
+		backups = backups[:keep]
+	}
+	return writeBackups(target, backups)
+}
+
+// takeBackup moves the backup of version (or the newest one if empty) out of the backup dir to path,
+// and removes it from the index
+func takeBackup(target, version, path string) (*binaryBackup, error) {
+	backups, err := readBackups(target)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(backups, func(b binaryBackup) bool {
+		return version == "" || b.Version == normalizeVer(version)
+	})
+	if i == -1 {
+		if version == "" {
+			return nil, errors.New("no backup to rollback to")
+		}
+		return nil, fmt.Errorf("no backup of v%s", normalizeVer(version))
+	}
+	backup := backups[i]
+	if err := os.Rename(filepath.Join(backupDir(target), backup.File), path); err != nil {
+		return nil, fmt.Errorf("take backup %s failed: %w", backup.File, err)
+	}
+	if err := writeBackups(target, slices.Delete(backups, i, i+1)); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// putBackBackup undoes takeBackup, moving the binary at path back into the backup dir with its
+// original entry, regardless of update.keep
+func putBackBackup(target, path string, backup binaryBackup) error {
+	backups, err := readBackups(target)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(backupDir(target), backup.File)); err != nil {
+		return fmt.Errorf("move %s back to backup dir failed: %w", path, err)
+	}
+	backups = append(backups, backup)
+	slices.SortStableFunc(backups, func(a, b binaryBackup) int {
+		return b.BackedUpAt.Compare(a.BackedUpAt)
+	})
+	return writeBackups(target, backups)
+}
+
+// newBinaryPath is where a binary is prepared before swapped to target
+func newBinaryPath(target string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".new")
+}
+
+// swapBinary replaces target by newPath, keeping the current one at oldPath. target is hard-linked to oldPath
+// first and replaced by a single rename, so there is always a binary at target
+func swapBinary(target, oldPath, newPath string) error {
+	if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale %s failed: %w", oldPath, err)
+	}
+	if err := os.Link(target, oldPath); err != nil {
+		return fmt.Errorf("backup current binary failed: %w", err)
+	}
+	if err := os.Rename(newPath, target); err != nil {
+		_ = os.Remove(oldPath)
+		return fmt.Errorf("replace binary failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/dn-11/wg-quick-op/lib/bird"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	birdStdout bool
+	birdReload bool
+)
+
+// birdCmd represents the bird command
+var birdCmd = &cobra.Command{
+	Use:   "bird",
+	Short: "manage BIRD BGP sessions of WireGuard peers",
+}
+
+var birdGenCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "generate BIRD BGP sessions for peers with ASN set",
+	Long: `generate BIRD BGP sessions for peers with ASN set in [Peer], using the host route in AllowedIPs
+(IPv6 link-local preferred) as neighbor, or the link-local address derived from the peer's public key if
+LinkLocal = auto in [Interface]. sessions are written to bird.output, inheriting bird.template`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if birdStdout {
+			b, err := bird.Render(daemon.BirdSessions(), conf.Bird.Template)
+			if err != nil {
+				log.Err(err).Msg("generate bird sessions failed")
+				os.Exit(1)
+			}
+			_, _ = os.Stdout.Write(b)
+			return
+		}
+		changed, err := daemon.GenerateBird()
+		if err != nil {
+			log.Err(err).Msg("generate bird sessions failed")
+			os.Exit(1)
+		}
+		if !changed {
+			log.Info().Str("output", conf.Bird.Output).Msg("bird sessions unchanged")
+			return
+		}
+		log.Info().Str("output", conf.Bird.Output).Msg("bird sessions generated")
+		if birdReload {
+			if err := daemon.ReloadBird(); err != nil {
+				log.Err(err).Msg("reload bird failed")
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	birdGenCmd.Flags().BoolVar(&birdStdout, "stdout", false, "print to stdout instead of bird.output")
+	birdGenCmd.Flags().BoolVar(&birdReload, "reload", false, "run bird.reload if changed")
+	birdCmd.AddCommand(birdGenCmd)
+	rootCmd.AddCommand(birdCmd)
+}
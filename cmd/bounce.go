@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -30,6 +31,7 @@ var bounceCmd = &cobra.Command{
 			}
 		}
 		log.Info().Msg("bounce done")
+		openwrt.Reload()
 	},
 }
 
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportIface string
+	exportForce bool
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export configs to other tools",
+}
+
+var exportUCICmd = &cobra.Command{
+	Use:   "uci",
+	Short: "export /etc/wireguard configs to luci-proto-wireguard interfaces in /etc/config/network",
+	Long: `export /etc/wireguard configs to luci-proto-wireguard interfaces in /etc/config/network,
+the UCI interface and its peers exported before with the same name are replaced, any other
+section of the name is only replaced with --force. directives UCI doesn't support
+(PreUp, PostUp, DNS, Table other than off, ...) are skipped with a warning.
+the network is not reloaded, bring the interface down with wg-quick-op first, then run 'ubus call network reload'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var only []string
+		if exportIface != "" {
+			only = []string{exportIface}
+		}
+		ifaces := utils.FindIface(only, nil)
+		if len(ifaces) == 0 {
+			log.Warn().Msg("no interface found in /etc/wireguard")
+			return
+		}
+
+		err := uci.Edit(openwrt.ConfDir, "network", func(network *uci.Config) (bool, error) {
+			var exported int
+			for _, iface := range ifaces {
+				logger := log.With().Str("iface", iface).Logger()
+				text, err := os.ReadFile(filepath.Join("/etc/wireguard", iface+".conf"))
+				if err != nil {
+					logger.Err(err).Msg("failed to read config")
+					continue
+				}
+				if err := openwrt.ExportUCI(network, iface, text, exportForce); err != nil {
+					logger.Err(err).Msg("failed to export interface")
+					continue
+				}
+				exported++
+				logger.Info().Msg("interface exported")
+			}
+			return exported > 0, nil
+		})
+		if err != nil {
+			log.Err(err).Msg("failed to update UCI network config")
+		}
+	},
+}
+
+func init() {
+	exportUCICmd.Flags().StringVarP(&exportIface, "iface", "i", "", "only export the given interface")
+	exportUCICmd.Flags().BoolVar(&exportForce, "force", false, "replace UCI sections of the same name not exported by wg-quick-op")
+	exportCmd.AddCommand(exportUCICmd)
+	rootCmd.AddCommand(exportCmd)
+}
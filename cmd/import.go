@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importIface string
+	importForce bool
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import configs from other tools",
+}
+
+var importUCICmd = &cobra.Command{
+	Use:   "uci",
+	Short: "import WireGuard interfaces of luci-proto-wireguard from /etc/config/network to /etc/wireguard",
+	Long: `import WireGuard interfaces of luci-proto-wireguard from /etc/config/network to /etc/wireguard/<iface>.conf,
+existing configs are kept unless --force is given. endpoints are not resolved.
+remember to disable the UCI interface after import, or both will fight for the link`,
+	Run: func(cmd *cobra.Command, args []string) {
+		network, err := uci.Load(openwrt.ConfDir, "network")
+		if err != nil {
+			log.Err(err).Msg("failed to load UCI network config")
+			return
+		}
+		ifaces := openwrt.WireguardInterfaces(network)
+		if importIface != "" {
+			ifaces = []string{importIface}
+		}
+		if len(ifaces) == 0 {
+			log.Warn().Msg("no WireGuard interface found in UCI network config")
+			return
+		}
+
+		for _, iface := range ifaces {
+			logger := log.With().Str("iface", iface).Logger()
+			text, err := openwrt.ImportUCI(network, iface)
+			if err != nil {
+				logger.Err(err).Msg("failed to import interface")
+				continue
+			}
+			path := filepath.Join("/etc/wireguard", iface+".conf")
+			if _, err := os.Stat(path); err == nil && !importForce {
+				logger.Warn().Str("path", path).Msg("config existed, use --force to overwrite")
+				continue
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Err(err).Str("path", path).Msg("failed to stat config")
+				continue
+			}
+			if err := os.MkdirAll("/etc/wireguard", 0700); err != nil {
+				logger.Err(err).Msg("failed to create /etc/wireguard")
+				return
+			}
+			if err := os.WriteFile(path, text, 0600); err != nil {
+				logger.Err(err).Str("path", path).Msg("failed to write config")
+				continue
+			}
+			logger.Info().Str("path", path).Msg("interface imported")
+		}
+	},
+}
+
+func init() {
+	importUCICmd.Flags().StringVarP(&importIface, "iface", "i", "", "only import the given interface")
+	importUCICmd.Flags().BoolVarP(&importForce, "force", "f", false, "overwrite existing configs")
+	importCmd.AddCommand(importUCICmd)
+	rootCmd.AddCommand(importCmd)
+}
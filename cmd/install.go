@@ -1,21 +1,48 @@
 package cmd
 
 import (
-	"github.com/dn-11/wg-quick-op/daemon"
+	"path/filepath"
 
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+var (
+	installBinary   string
+	installInit     string
+	installNoEnable bool
+	installTemplate bool
+)
+
 // installCmd represents the install command
 var installCmd = &cobra.Command{
-	Use:   "install",
+	Use:   "install [-- extra service args]",
 	Short: "install wg-quick-op to /usr/sbin/wg-quick-op",
+	Long: `install wg-quick-op to /usr/sbin/wg-quick-op, install the service for the init system, then enable and start it.
+init system is detected unless --init is given.
+with --template on systemd, wg-quick-op@.service is installed as well to manage each interface by its own unit,
+the units of interfaces selected by start_on_boot are enabled, and the service won't up them again.
+service files are rendered with the binary path, -c config path, --log-level and extra service args after --`,
 	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := filepath.Abs(config)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("get absolute path of %s failed", config)
+		}
 		daemon.Install()
-		daemon.AddService()
+		daemon.AddService(installInit, daemon.ServiceParams{
+			Binary:    installBinary,
+			Config:    configPath,
+			LogLevel:  logLevel,
+			ExtraArgs: args,
+		}, !installNoEnable, installTemplate)
 	},
 }
 
 func init() {
+	installCmd.Flags().StringVar(&installBinary, "binary", "", "binary path in service files, the one in PATH is used if empty")
+	installCmd.Flags().StringVar(&installInit, "init", "", "init system: systemd|procd|openrc|runit|s6, detected if empty")
+	installCmd.Flags().BoolVar(&installNoEnable, "no-enable", false, "only install service files, do not enable and start the service")
+	installCmd.Flags().BoolVar(&installTemplate, "template", false, "also install systemd template unit wg-quick-op@.service")
 	rootCmd.AddCommand(installCmd)
 }
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/spf13/cobra"
+)
+
+var kickWAN string
+
+// kickCmd represents the kick command
+var kickCmd = &cobra.Command{
+	Use:   "kick",
+	Short: "re-resolve endpoints of all managed interfaces immediately",
+	Long: `re-resolve endpoints of all managed interfaces immediately, and randomize ListenPort not set by config
+if wireguard.random_port is enabled. it's called by the hotplug script on OpenWrt when WAN goes up,
+with --wan set to the UCI interface, which is ignored if not listed in openwrt.wan`,
+	Run: func(cmd *cobra.Command, args []string) {
+		daemon.Kick(kickWAN)
+	},
+}
+
+func init() {
+	kickCmd.Flags().StringVar(&kickWAN, "wan", "", "UCI interface triggering the kick")
+	rootCmd.AddCommand(kickCmd)
+}
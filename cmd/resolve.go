@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/lib/dns"
+	"github.com/dn-11/wg-quick-op/quick"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var resolveIface string
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve [host[:port]]",
+	Short: "resolve endpoint with both system and direct resolver, for debugging",
+	Long: `resolve endpoint with both system resolver and direct resolver, print details of the direct resolver,
+including CNAME chain, NS servers, each query with its answering server, TTL and timing.
+SRV names like _wireguard._udp.peer.example.dn11 are looked up and their targets resolved by both as well.
+use --iface to resolve all endpoints of an interface`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var endpoints []string
+		if resolveIface != "" {
+			unresolved, err := quick.GetUnresolvedEndpoints(resolveIface)
+			if err != nil {
+				log.Err(err).Str("iface", resolveIface).Msg("failed to get unresolved endpoints")
+				return
+			}
+			for key, endpoint := range unresolved {
+				fmt.Printf("peer %s: %s\n", key, endpoint)
+				endpoints = append(endpoints, endpoint)
+			}
+			slices.Sort(endpoints)
+			endpoints = slices.Compact(endpoints)
+		}
+		endpoints = append(endpoints, args...)
+		if len(endpoints) == 0 {
+			log.Error().Msg("resolve command requires a host or --iface")
+			return
+		}
+
+		for _, endpoint := range endpoints {
+			fmt.Printf("\n== %s\n", endpoint)
+			resolveEndpoint(endpoint)
+		}
+	},
+}
+
+func resolveEndpoint(endpoint string) {
+	trace := &dns.Trace{}
+	ctx := dns.WithTrace(context.Background(), trace)
+	if dns.IsSRVName(endpoint) {
+		start := time.Now()
+		addr, err := dns.ResolveSRVSystem(endpoint)
+		printResolved("system SRV", addr, err, time.Since(start))
+
+		start = time.Now()
+		addr, err = dns.ResolveSRVDirectContext(ctx, endpoint)
+		printResolved("direct SRV", addr, err, time.Since(start))
+		printTrace(trace)
+		return
+	}
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		endpoint = net.JoinHostPort(endpoint, "51820")
+	}
+
+	start := time.Now()
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	printResolved("system", addr, err, time.Since(start))
+
+	start = time.Now()
+	addr, err = dns.ResolveUDPAddrDirectContext(ctx, endpoint)
+	printResolved("direct", addr, err, time.Since(start))
+	printTrace(trace)
+}
+
+// printTrace prints details of the direct resolver
+func printTrace(trace *dns.Trace) {
+	if len(trace.CNAMEs) > 0 {
+		fmt.Printf("  CNAME: %s\n", strings.Join(trace.CNAMEs, " -> "))
+	}
+	if len(trace.NS) > 0 {
+		var ns []string
+		for _, s := range trace.NS {
+			ns = append(ns, s.String())
+		}
+		fmt.Printf("  NS: %s\n", strings.Join(ns, ", "))
+	}
+	if trace.DNSSEC != dns.StatusUnknown {
+		fmt.Printf("  DNSSEC: %s\n", trace.DNSSEC)
+	}
+	for _, q := range trace.Queries {
+		if q.Err != nil {
+			fmt.Printf("  %s %s @%s error: %v (%s)\n", q.Name, q.Type, q.Server, q.Err, q.RTT)
+			continue
+		}
+		fmt.Printf("  %s %s @%s %s answers=%d ttl=%d (%s)\n", q.Name, q.Type, q.Server, q.Rcode, q.Answers, q.TTL, q.RTT)
+	}
+}
+
+func printResolved(resolver string, addr *net.UDPAddr, err error, cost time.Duration) {
+	if err != nil {
+		fmt.Printf("%s resolver: error: %v (%s)\n", resolver, err, cost.Truncate(time.Microsecond))
+		return
+	}
+	fmt.Printf("%s resolver: %s (%s)\n", resolver, addr, cost.Truncate(time.Microsecond))
+}
+
+func init() {
+	resolveCmd.Flags().StringVarP(&resolveIface, "iface", "i", "", "resolve all endpoints of the interface")
+	rootCmd.AddCommand(resolveCmd)
+}
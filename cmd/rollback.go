@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackTo            string
+	rollbackList          bool
+	rollbackNoRestart     bool
+	rollbackHealthTimeout time.Duration
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:          "rollback",
+	Short:        "Rollback to a previous binary kept by update",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, oldPath, err := installedPaths()
+		if err != nil {
+			return err
+		}
+
+		if rollbackList {
+			backups, err := readBackups(target)
+			if err != nil {
+				return err
+			}
+			if len(backups) == 0 {
+				fmt.Println("no backups")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tBACKED UP AT")
+			for _, b := range backups {
+				fmt.Fprintf(w, "v%s\t%s\n", b.Version, b.BackedUpAt.Format(time.DateTime))
+			}
+			return w.Flush()
+		}
+
+		cur := normalizeVer(version)
+		newPath := newBinaryPath(target)
+		backup, err := takeBackup(target, rollbackTo, newPath)
+		if err != nil {
+			return err
+		}
+		// put the backup back into the backup dir, as the running one is untouched yet
+		restore := func(stage string, cause error) error {
+			if rerr := putBackBackup(target, newPath, *backup); rerr != nil {
+				return fmt.Errorf("%s: %v ,restore backup failed: %w", stage, cause, rerr)
+			}
+			return fmt.Errorf("%s: %w", stage, cause)
+		}
+		if err := sanityCheckVersion(newPath, backup.Version); err != nil {
+			return restore("sanity check failed", err)
+		}
+
+		fmt.Printf("Rolling back from v%s to v%s...\n", cur, backup.Version)
+		if err := swapBinary(target, oldPath, newPath); err != nil {
+			return restore("swap binary failed", err)
+		}
+
+		if !rollbackNoRestart {
+			// the binary rolled back to is out of the backup dir, put it back before it's replaced
+			keepFailed := func() error {
+				if err := os.Link(target, newPath); err != nil {
+					return err
+				}
+				return putBackBackup(target, newPath, *backup)
+			}
+			if err := restartOrRollback(target, oldPath, rollbackHealthTimeout, keepFailed); err != nil {
+				return err
+			}
+		}
+		// keep the current one, so it can be rolled forward with --to
+		if err := keepBackup(target, oldPath, cur, conf.Update.Keep); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: keep backup of v%s failed: %v\n", cur, err)
+		}
+		fmt.Printf("Rollback done: v%s\n", backup.Version)
+		return nil
+	},
+}
+
+func init() {
+	rollbackCmd.Flags().StringVar(&rollbackTo, "to", "", "Version to rollback to, the newest backup by default")
+	rollbackCmd.Flags().BoolVar(&rollbackList, "list", false, "List kept backups")
+	rollbackCmd.Flags().BoolVar(&rollbackNoRestart, "no-restart", false, "Do not restart service after rollback")
+	rollbackCmd.Flags().DurationVar(&rollbackHealthTimeout, "health-timeout", 60*time.Second, "Time to wait for the restarted service to be healthy before rolling forward")
+	rollbackCmd.MarkFlagsMutuallyExclusive("to", "list")
+
+	rootCmd.AddCommand(rollbackCmd)
+}
@@ -16,7 +16,8 @@ var rootCmd = &cobra.Command{
 }
 
 var (
-	config string
+	config   string
+	logLevel string
 )
 
 func Execute() {
@@ -33,8 +34,10 @@ func init() {
 		if verbose {
 			zerolog.SetGlobalLevel(zerolog.TraceLevel)
 		}
+		conf.LogLevelOverride = logLevel
 		conf.Init(config)
 		dns.Init()
 	}
 	rootCmd.PersistentFlags().StringVarP(&config, "config", "c", "/etc/wg-quick-op.toml", "config file path")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level, overwrite log.level in config")
 }
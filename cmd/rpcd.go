@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
+	"github.com/dn-11/wg-quick-op/quick"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+type rpcdArgs struct {
+	Iface string `json:"iface"`
+}
+
+type rpcdMethod struct {
+	// signature reported by list, rpcd uses it to check the arguments
+	signature map[string]string
+	call      func(args rpcdArgs) (any, error)
+}
+
+var rpcdMethods = map[string]rpcdMethod{
+	"status": {
+		signature: map[string]string{},
+		call: func(_ rpcdArgs) (any, error) {
+			return daemon.ReadStatus()
+		},
+	},
+	"up": {
+		signature: map[string]string{"iface": "str"},
+		call: func(args rpcdArgs) (any, error) {
+			return rpcdEachIface(args.Iface, quick.ParseFull, func(iface string, cfg *quick.Config) (any, error) {
+				return nil, quick.Up(cfg, iface, log.With().Str("iface", iface).Logger())
+			})
+		},
+	},
+	"down": {
+		signature: map[string]string{"iface": "str"},
+		call: func(args rpcdArgs) (any, error) {
+			return rpcdEachIface(args.Iface, quick.ParseNoPeer, func(iface string, cfg *quick.Config) (any, error) {
+				return nil, quick.Down(cfg, iface, log.With().Str("iface", iface).Logger())
+			})
+		},
+	},
+	"bounce": {
+		signature: map[string]string{"iface": "str"},
+		call: func(args rpcdArgs) (any, error) {
+			return rpcdEachIface(args.Iface, quick.ParseFull, func(iface string, cfg *quick.Config) (any, error) {
+				logger := log.With().Str("iface", iface).Logger()
+				if err := quick.Down(cfg, iface, logger); err != nil {
+					logger.Err(err).Msg("failed to down interface")
+				}
+				return nil, quick.Up(cfg, iface, logger)
+			})
+		},
+	},
+	"resolve": {
+		signature: map[string]string{"iface": "str"},
+		call: func(args rpcdArgs) (any, error) {
+			return rpcdEachIface(args.Iface, quick.ParseNoPeer, func(iface string, _ *quick.Config) (any, error) {
+				return daemon.Resolve(iface)
+			})
+		},
+	},
+}
+
+type rpcdIfaceResult struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// rpcdEachIface runs f on each interface matching pattern, reporting result per interface
+func rpcdEachIface(pattern string, mode quick.ParseMode, f func(iface string, cfg *quick.Config) (any, error)) (any, error) {
+	if pattern == "" {
+		return nil, errors.New("iface is required")
+	}
+	cfgs := quick.MatchConfig(pattern, mode)
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no interface matches %s", pattern)
+	}
+	results := make(map[string]rpcdIfaceResult)
+	for iface, cfg := range cfgs {
+		result, err := f(iface, cfg)
+		if err != nil {
+			log.Err(err).Str("iface", iface).Msg("rpcd call failed")
+			results[iface] = rpcdIfaceResult{Error: err.Error()}
+			continue
+		}
+		results[iface] = rpcdIfaceResult{Result: result}
+	}
+	openwrt.Reload()
+	return map[string]any{"ifaces": results}, nil
+}
+
+// rpcdCmd represents the rpcd command
+var rpcdCmd = &cobra.Command{
+	Use:    "rpcd list|call [method]",
+	Short:  "rpcd exec plugin backend, exposing ubus object wg-quick-op",
+	Hidden: true,
+	Args:   cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var out any
+		switch {
+		case args[0] == "list":
+			list := make(map[string]map[string]string)
+			for name, method := range rpcdMethods {
+				list[name] = method.signature
+			}
+			out = list
+		case args[0] == "call" && len(args) == 2:
+			out = rpcdCall(args[1], os.Stdin)
+		default:
+			log.Error().Msg("usage: rpcd list|call [method]")
+			os.Exit(1)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			log.Err(err).Msg("write rpcd output failed")
+		}
+	},
+}
+
+func rpcdCall(name string, input io.Reader) any {
+	method, ok := rpcdMethods[name]
+	if !ok {
+		return map[string]string{"error": "unknown method " + name}
+	}
+	var args rpcdArgs
+	b, err := io.ReadAll(input)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &args); err != nil {
+			return map[string]string{"error": "invalid arguments: " + err.Error()}
+		}
+	}
+	result, err := method.call(args)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return result
+}
+
+func init() {
+	rootCmd.AddCommand(rpcdCmd)
+}
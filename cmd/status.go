@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show status reported by the running service",
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := daemon.ReadStatus()
+		if err != nil {
+			log.Err(err).Msgf("read status from %s failed, is the service running?", daemon.StatusPath)
+			return
+		}
+
+		fmt.Printf("updated at: %s\n", status.UpdatedAt.Format(time.DateTime))
+		if u := status.Update; u != nil {
+			if !u.CheckedAt.IsZero() {
+				fmt.Printf("auto update: %s at %s %s\n", u.Result, u.CheckedAt.Format(time.DateTime), u.Version)
+				if u.Error != "" {
+					fmt.Printf("auto update error: %s\n", u.Error)
+				}
+			}
+			if !u.Next.IsZero() {
+				fmt.Printf("next auto update: %s\n", u.Next.Format(time.DateTime))
+			}
+		}
+		var names []string
+		for name := range status.Ifaces {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "IFACE\tPEER\tENDPOINT\tRESOLVED\tHANDSHAKE\tDNSSEC\tPROBE")
+		for _, name := range names {
+			for _, peer := range status.Ifaces[name].Peers {
+				handshake := "never"
+				if !peer.LastHandshake.IsZero() {
+					handshake = time.Since(peer.LastHandshake).Truncate(time.Second).String() + " ago"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, peer.PublicKey, orDash(peer.Endpoint), orDash(peer.Resolved), handshake, orDash(peer.DNSSEC), probeResult(peer))
+			}
+		}
+		_ = w.Flush()
+	},
+}
+
+func probeResult(peer *daemon.PeerStatus) string {
+	switch {
+	case peer.Probe == "":
+		return "-"
+	case peer.ProbeError != "":
+		return peer.Probe + " failed"
+	case peer.ProbeRTT == 0:
+		return peer.Probe + " pending"
+	}
+	return fmt.Sprintf("%s %.1fms", peer.Probe, peer.ProbeRTT)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
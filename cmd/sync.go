@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -18,12 +20,21 @@ it may result in address added by PostUp being deleted.'`,
 			return
 		}
 		cfgs := quick.MatchConfig(args[0], quick.ParseFull)
+		if len(cfgs) == 0 {
+			log.Error().Msgf("no interface matches %s", args[0])
+			os.Exit(1)
+		}
+		var failed bool
 		for iface, cfg := range cfgs {
 			err := quick.Sync(cfg, iface, log.With().Str("iface", iface).Logger())
 			if err != nil {
 				log.Err(err).Msg("failed to sync interface")
+				failed = true
 			}
 		}
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
 
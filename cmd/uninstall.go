@@ -6,16 +6,19 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var uninstallInit string
+
 // uninstallCmd represents the uninstallation command
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "uninstall wg-quick-op from /usr/sbin/wg-quick-op",
 	Run: func(cmd *cobra.Command, args []string) {
-		daemon.RmService()
+		daemon.RmService(uninstallInit)
 		daemon.Uninstall()
 	},
 }
 
 func init() {
+	uninstallCmd.Flags().StringVar(&uninstallInit, "init", "", "init system: systemd|procd|openrc|runit|s6, detected if empty")
 	rootCmd.AddCommand(uninstallCmd)
 }
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"os"
+
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -20,12 +23,22 @@ regexp in supported, match interface with ^<input>$ by default
 			return
 		}
 		cfgs := quick.MatchConfig(args[0], quick.ParseFull)
+		if len(cfgs) == 0 {
+			log.Error().Msgf("no interface matches %s", args[0])
+			os.Exit(1)
+		}
+		var failed bool
 		for iface, cfg := range cfgs {
 			err := quick.Up(cfg, iface, log.With().Str("iface", iface).Logger())
 			if err != nil {
 				log.Err(err).Msg("failed to up interface")
+				failed = true
 			}
 		}
+		openwrt.Reload()
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
 
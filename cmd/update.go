@@ -13,37 +13,135 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/daemon"
+	"github.com/dn-11/wg-quick-op/lib/minisign"
 	"github.com/spf13/cobra"
 )
 
 type updateSource string
 
 const (
-	sourceAuto   updateSource = "auto"   // mirror -> github(default)
+	sourceAuto   updateSource = "auto"   // update.sources in config, or mirror -> github(default)
 	sourceMirror updateSource = "mirror" // mirror
 	sourceGitHub updateSource = "github" // github
+	// sourceURLPrefix prefixes a self-hosted source in the mirror layout:
+	// <base>/release_latest.json in GitHub release JSON, and assets at <base>/latest/<asset>
+	sourceURLPrefix = "url:"
 )
 
+// base returns the base URL of sources in the mirror layout
+func (s updateSource) base() (string, bool) {
+	if s == sourceMirror {
+		return mirrorBase, true
+	}
+	if base, ok := strings.CutPrefix(string(s), sourceURLPrefix); ok {
+		return strings.TrimSuffix(base, "/"), true
+	}
+	return "", false
+}
+
+// trusted reports whether checksums of the source are trusted without signature, only GitHub is,
+// mirrors and self-hosted sources are third parties
+func (s updateSource) trusted() bool {
+	return s == sourceGitHub
+}
+
+func (s updateSource) validate() error {
+	if s == sourceMirror || s == sourceGitHub {
+		return nil
+	}
+	if base, ok := s.base(); ok {
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid source %s, expected url:http(s)://host/path", s)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid source %s, expected auto|mirror|github|url:<base>", s)
+}
+
+// updateSources returns sources to try in order for --source
+func updateSources(flag string) ([]updateSource, error) {
+	var sources []updateSource
+	if updateSource(flag) == sourceAuto {
+		for _, s := range conf.Update.Sources {
+			sources = append(sources, updateSource(s))
+		}
+		if len(sources) == 0 {
+			sources = []updateSource{sourceMirror, sourceGitHub}
+		}
+	} else {
+		sources = []updateSource{updateSource(flag)}
+	}
+
+	for _, s := range sources {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return sources, nil
+}
+
 var (
 	updateSourceFlag string = string(sourceAuto)
 	mirrorBase              = "https://mirror.macaronss.top/github/dn-11/wg-quick-op/releases"
 )
 
+// releasePublicKey is the minisign public key of official releases, verifying signature of release checksums.
+// It must be the public half of the MINISIGN_SECRET_KEY the release workflow signs with, release builds embed it
+// by MINISIGN_PUBLIC_KEY until it is filled in here
+const releasePublicKey = ""
+
+// updatePublicKey overrides releasePublicKey for forks signed by another key,
+// set by -ldflags "-X github.com/dn-11/wg-quick-op/cmd.updatePublicKey=<base64 key>"
+var updatePublicKey string
+
+// publicKey returns the key verifying release checksums, or nil if this build has none
+func publicKey() (*minisign.PublicKey, error) {
+	key := updatePublicKey
+	if key == "" {
+		key = releasePublicKey
+	}
+	if key == "" {
+		return nil, nil
+	}
+	pk, err := minisign.ParsePublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("embedded public key is invalid: %w", err)
+	}
+	return pk, nil
+}
+
 type ghRelease struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
+	TagName    string `json:"tag_name"`
+	Prerelease bool   `json:"prerelease"`
+	Draft      bool   `json:"draft"`
+	Assets     []struct {
 		Name string `json:"name"`
 		URL  string `json:"browser_download_url"`
 	} `json:"assets"`
+
+	// latest is set if fetched as the latest release, whose assets are at <base>/latest/ of mirror layout
+	latest bool
 }
 
+type releaseChannel string
+
+const (
+	channelStable     releaseChannel = "stable"     // latest release
+	channelPrerelease releaseChannel = "prerelease" // newest release, including prereleases
+)
+
 var (
 	updateCheckOnly bool
 	updateForce     bool
@@ -52,6 +150,15 @@ var (
 
 	noUpdateSyncService     bool
 	updateSyncServiceStrict bool
+
+	updateFromFile  string
+	updateChecksums string
+
+	updateHealthTimeout time.Duration
+
+	updateChannel string
+	updateVersion string
+	updateList    bool
 )
 
 var updateCmd = &cobra.Command{
@@ -64,73 +171,142 @@ var updateCmd = &cobra.Command{
 			ctxTimeout = 120 * time.Second
 		}
 
-		// check --source
-		usedFlag := updateSource(updateSourceFlag)
-		switch usedFlag {
-		case sourceAuto, sourceMirror, sourceGitHub:
-		default:
-			return fmt.Errorf("invalid --source, expected auto|mirror|github")
+		channel := releaseChannel(updateChannel)
+		if channel != channelStable && channel != channelPrerelease {
+			return fmt.Errorf("invalid channel %s, expected stable|prerelease", updateChannel)
+		}
+		if updateList {
+			sources, err := updateSources(updateSourceFlag)
+			if err != nil {
+				return err
+			}
+			return listReleases(sources, ctxTimeout, channel)
 		}
 
-		rel, used, err := fetchLatestReleaseWithSource(ctxTimeout, usedFlag)
+		// fail on a broken key before downloading anything
+		pk, err := publicKey()
 		if err != nil {
 			return err
 		}
-		latest := normalizeVer(rel.TagName)
+		if pk == nil {
+			fmt.Fprintln(os.Stderr, "WARN: no release public key in this build, signatures are not verified and only GitHub is used as source")
+		}
+
 		cur := normalizeVer(version)
+		var (
+			latest  string
+			extract func(outPath string) error
+		)
+		if updateFromFile != "" {
+			// offline update, still verified against the checksums file
+			fileVer, expected, err := verifyLocalArchive(updateFromFile, updateChecksums)
+			if err != nil {
+				return err
+			}
+			latest = fileVer
+			if updateCheckOnly {
+				fmt.Printf("File: v%s, current: v%s, checksum verified\n", latest, cur)
+				return nil
+			}
+			extract = func(outPath string) error {
+				return extractVerifyTarGzFile(updateFromFile, outPath, expected)
+			}
+		} else {
+			sources, err := updateSources(updateSourceFlag)
+			if err != nil {
+				return err
+			}
+			if pk == nil {
+				// third-party sources are trusted only by signature
+				sources = slices.DeleteFunc(sources, func(s updateSource) bool { return !s.trusted() })
+				if len(sources) == 0 {
+					return errors.New("signature is mandatory for mirror and self-hosted sources, but this build has no release public key, use --source github")
+				}
+			}
+			rel, used, err := fetchWithSource(sources, func(source updateSource) (*ghRelease, error) {
+				return fetchRelease(source, ctxTimeout, channel, updateVersion)
+			})
+			if err != nil {
+				return err
+			}
+			latest = normalizeVer(rel.TagName)
 
-		if updateCheckOnly {
-			fmt.Printf("Latest: v%s, current: v%s, source: %s\n", latest, cur, used)
-			return nil
-		}
+			if updateCheckOnly {
+				if updateVersion != "" {
+					fmt.Printf("Found: v%s, current: v%s, source: %s\n", latest, cur, used)
+				} else {
+					fmt.Printf("Latest (%s): v%s, current: v%s, source: %s\n", channel, latest, cur, used)
+				}
+				return nil
+			}
 
-		if !updateForce && cur != "" && latest != "" && cur == latest {
-			fmt.Printf("Already latest: v%s\n", latest)
-			return nil
-		}
+			if !updateForce && updateVersion == "" && cur != "" && latest != "" && cur == latest {
+				fmt.Printf("Already latest: v%s\n", latest)
+				return nil
+			}
 
-		assetName := targetAssetName()
+			assetName := targetAssetName()
 
-		// Use release.json to ensure the asset exists, then fetch its URL.
-		assetURL, err := setAssetURL(rel, used, assetName)
-		if err != nil {
-			return err
-		}
+			// Use release.json to ensure the asset exists, then fetch its URL.
+			assetURL, err := setAssetURL(rel, used, assetName)
+			if err != nil {
+				return err
+			}
 
-		// Perform in-memory parsing of checksums
-		sumName := checksumAssetName(latest)
-		sumURL, err := setAssetURL(rel, used, sumName)
-		if err != nil {
-			return err
+			// Perform in-memory parsing of checksums
+			sumName := checksumAssetName(latest)
+			sumURL, err := setAssetURL(rel, used, sumName)
+			if err != nil {
+				return err
+			}
+
+			sumBytes, err := downloadToBytes(sumURL, ctxTimeout, 2<<20) // Up to 2MB
+			if err != nil {
+				return err
+			}
+			// verified before touching the binary, nothing to rollback on mismatch
+			if err := verifyChecksums(rel, used, sumName, sumBytes, ctxTimeout); err != nil {
+				return err
+			}
+			expected, err := expectedSHAFromChecksumsBytes(sumBytes, assetName)
+			if err != nil {
+				return err
+			}
+			// Stream download tar.gz -> gzip -> tar, write directly to target,
+			// while teeing the stream for SHA256 verification.
+			extract = func(outPath string) error {
+				return streamExtractVerifyTarGzToPath(assetURL, outPath, expected, ctxTimeout)
+			}
 		}
 
-		sumBytes, err := downloadToBytes(sumURL, ctxTimeout, 2<<20) // Up to 2MB
-		if err != nil {
-			return err
+		if (updateFromFile != "" || updateVersion != "") && !updateForce && cur != "" && cur == latest {
+			fmt.Printf("Already v%s, use --force to reinstall\n", latest)
+			return nil
 		}
-		expected, err := expectedSHAFromChecksumsBytes(sumBytes, assetName)
+
+		target, oldPath, err := installedPaths()
 		if err != nil {
 			return err
 		}
 
-		target, err := os.Executable()
-		if err != nil {
-			return fmt.Errorf("get executable path failed: %w", err)
-		}
-		if t, err := filepath.EvalSymlinks(target); err == nil {
-			target = t
-		}
+		fmt.Printf("Updating to v%s...\n", latest)
 
-		oldPath := filepath.Join(
-			filepath.Dir(target),
-			"."+filepath.Base(target)+".old",
-		)
+		// prepare the new binary next to the installed one, the installed one is untouched until swapped
+		newPath := newBinaryPath(target)
+		if err := extract(newPath); err != nil {
+			_ = os.Remove(newPath)
+			return fmt.Errorf("extract new binary failed: %w", err)
+		}
 
-		fmt.Printf("Updating to v%s...\n", latest)
+		// Self-check for the new version
+		if err := sanityCheckVersion(newPath, latest); err != nil {
+			_ = os.Remove(newPath)
+			return fmt.Errorf("sanity check failed: %w", err)
+		}
 
-		// Backup the old file
-		if err := os.Rename(target, oldPath); err != nil {
-			return fmt.Errorf("backup old binary failed: %w", err)
+		if err := swapBinary(target, oldPath, newPath); err != nil {
+			_ = os.Remove(newPath)
+			return err
 		}
 
 		rollback := func(stage string, cause error) error {
@@ -140,17 +316,6 @@ var updateCmd = &cobra.Command{
 			return fmt.Errorf("%s: %w", stage, cause)
 		}
 
-		// Stream download tar.gz -> gzip -> tar, write directly to target,
-		// while teeing the stream for SHA256 verification.
-		if err := streamExtractVerifyTarGzToPath(assetURL, target, expected, ctxTimeout); err != nil {
-			return rollback("extract new binary failed", err)
-		}
-
-		// Self-check for the new version
-		if err := sanityCheckVersion(target, latest); err != nil {
-			return rollback("sanity check failed", err)
-		}
-
 		// Sync service scripts
 		if !noUpdateSyncService {
 			if err := trySyncServiceScripts(target); err != nil {
@@ -161,22 +326,14 @@ var updateCmd = &cobra.Command{
 			}
 		}
 		// restart service
-		if !updateNoRestart && fileExists("/etc/init.d/wg-quick-op") {
-			fmt.Println("restarting service...")
-			if err := exec.Command("/etc/init.d/wg-quick-op", "restart").Run(); err != nil {
-				if rerr := os.Rename(oldPath, target); rerr != nil {
-					return fmt.Errorf("restart new service failed: %w , fallback failed: %w", err, rerr)
-				} else {
-					if e := exec.Command("/etc/init.d/wg-quick-op", "start").Run(); e != nil {
-						return fmt.Errorf("restart new service failed: %w ,fallback success ,restart old service failed: %w", err, e)
-					}
-					return fmt.Errorf("restart new service failed: %w ,fallback success ,Restart old service success", err)
-				}
+		if !updateNoRestart {
+			if err := restartOrRollback(target, oldPath, updateHealthTimeout, nil); err != nil {
+				return err
 			}
-		} else if !updateNoRestart {
-			fmt.Println("/etc/init.d/wg-quick-op not found ,please restart new service manually")
 		}
-		_ = os.Remove(oldPath)
+		if err := keepBackup(target, oldPath, cur, conf.Update.Keep); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: keep backup of v%s failed: %v\n", cur, err)
+		}
 		fmt.Printf("Update done: v%s\n", latest)
 		return nil
 	},
@@ -187,99 +344,170 @@ func init() {
 	updateCmd.Flags().BoolVar(&updateForce, "force", false, "Force update even if already latest")
 	updateCmd.Flags().BoolVar(&updateNoRestart, "no-restart", false, "Do not restart service after updating")
 	updateCmd.Flags().DurationVar(&updateTimeout, "timeout", 600*time.Second, "Network timeout")
+	updateCmd.Flags().DurationVar(&updateHealthTimeout, "health-timeout", 60*time.Second, "Time to wait for the restarted service to be healthy before rolling back")
 	updateCmd.Flags().StringVar(
 		&updateSourceFlag,
 		"source",
 		string(sourceAuto),
 		`Update source:
-  auto    : mirror -> github
-  mirror  : https://mirror.macaronss.top/github/dn-11/wg-quick-op/releases
-  github  : https://api.github.com/repos/dn-11/wg-quick-op/releases`,
+  auto       : update.sources in config, or mirror -> github
+  mirror     : https://mirror.macaronss.top/github/dn-11/wg-quick-op/releases
+  github     : https://api.github.com/repos/dn-11/wg-quick-op/releases
+  url:<base> : self-hosted, <base>/release_latest.json and <base>/latest/<asset>,
+               <base>/releases.json and <base>/download/<tag>/<asset> for --channel prerelease, --version and --list`,
 	)
-	updateCmd.Flags().BoolVar(&noUpdateSyncService, "no-sync-service", false, "Do not sync service scripts (systemd unit / OpenWrt init.d) after updating")
+	updateCmd.Flags().StringVar(&updateChannel, "channel", string(channelStable), "Release channel: stable|prerelease")
+	updateCmd.Flags().StringVar(&updateVersion, "version", "", "Install the specific version, including downgrade")
+	updateCmd.Flags().BoolVar(&updateList, "list", false, "List available versions of the channel")
+	updateCmd.Flags().StringVar(&updateFromFile, "from-file", "", "Update from a local release tar.gz, offline")
+	updateCmd.Flags().StringVar(&updateChecksums, "checksums", "", "Checksums file for --from-file, wg-quick-op_<version>_checksums.txt next to it by default")
+	updateCmd.Flags().BoolVar(&noUpdateSyncService, "no-sync-service", false, "Do not sync service scripts (systemd unit / init.d / runit / s6) after updating")
 	updateCmd.Flags().BoolVar(&updateSyncServiceStrict, "sync-service-strict", false, "Fail update if syncing service scripts fails")
+	updateCmd.MarkFlagsMutuallyExclusive("from-file", "version")
+	updateCmd.MarkFlagsMutuallyExclusive("from-file", "list")
 
 	rootCmd.AddCommand(updateCmd)
 }
 
-func fetchLatestRelease(timeout time.Duration) (*ghRelease, error) {
-	url := "https://api.github.com/repos/dn-11/wg-quick-op/releases/latest"
+const githubReleasesAPI = "https://api.github.com/repos/dn-11/wg-quick-op/releases"
+
+// installedPaths returns path of the running binary, and where it is moved to while being replaced
+func installedPaths() (string, string, error) {
+	target, err := os.Executable()
+	if err != nil {
+		return "", "", fmt.Errorf("get executable path failed: %w", err)
+	}
+	if t, err := filepath.EvalSymlinks(target); err == nil {
+		target = t
+	}
+	oldPath := filepath.Join(
+		filepath.Dir(target),
+		"."+filepath.Base(target)+".old",
+	)
+	return target, oldPath, nil
+}
+
+func fetchJSON(url string, timeout time.Duration, v any) error {
 	client := &http.Client{Timeout: timeout}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("build request failed: %w", err)
+		return fmt.Errorf("build request failed: %w", err)
 	}
 	req.Header.Set("User-Agent", "wg-quick-op-updater")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetch latest release failed: %w", err)
+		return fmt.Errorf("fetch %s failed: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode/100 != 2 {
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("github api error: %s: %s", resp.Status, string(b))
+		return fmt.Errorf("%s error: %s: %s", url, resp.Status, string(b))
 	}
 
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s json failed: %w", url, err)
+	}
+	return nil
+}
+
+// fetchLatestRelease fetches the latest stable release, sources in the mirror layout serve it
+// at <base>/release_latest.json
+func fetchLatestRelease(source updateSource, timeout time.Duration) (*ghRelease, error) {
+	url := githubReleasesAPI + "/latest"
+	if base, ok := source.base(); ok {
+		url = base + "/release_latest.json"
+	}
 	var rel ghRelease
-	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
-		return nil, fmt.Errorf("decode github api json failed: %w", err)
+	if err := fetchJSON(url, timeout, &rel); err != nil {
+		return nil, err
 	}
 	if rel.TagName == "" {
-		return nil, errors.New("no tag_name in latest release")
+		return nil, fmt.Errorf("no tag_name in %s", url)
 	}
+	rel.latest = true
 	return &rel, nil
 }
 
-func fetchLatestReleaseFromMirror(timeout time.Duration) (*ghRelease, error) {
-	url := mirrorBase + "/release_latest.json"
-
-	client := &http.Client{Timeout: timeout}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("build request failed: %w", err)
+// fetchReleases fetches releases newest first, sources in the mirror layout serve them
+// at <base>/releases.json
+func fetchReleases(source updateSource, timeout time.Duration) ([]ghRelease, error) {
+	url := githubReleasesAPI + "?per_page=100"
+	if base, ok := source.base(); ok {
+		url = base + "/releases.json"
 	}
-	req.Header.Set("User-Agent", "wg-quick-op-updater")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("fetch mirror latest release failed: %w", err)
+	var rels []ghRelease
+	if err := fetchJSON(url, timeout, &rels); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return slices.DeleteFunc(rels, func(rel ghRelease) bool {
+		return rel.Draft || rel.TagName == ""
+	}), nil
+}
 
-	if resp.StatusCode/100 != 2 {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("mirror api error: %s: %s", resp.Status, string(b))
+// fetchRelease fetches the release pinned by version, or the newest one of channel
+func fetchRelease(source updateSource, timeout time.Duration, channel releaseChannel, version string) (*ghRelease, error) {
+	if version == "" && channel == channelStable {
+		return fetchLatestRelease(source, timeout)
 	}
-
-	var rel ghRelease
-	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
-		return nil, fmt.Errorf("decode mirror json failed: %w", err)
+	rels, err := fetchReleases(source, timeout)
+	if err != nil {
+		return nil, err
 	}
-	if rel.TagName == "" {
-		return nil, errors.New("no tag_name in mirror latest release")
+	for i := range rels {
+		if version != "" && normalizeVer(rels[i].TagName) != normalizeVer(version) {
+			continue
+		}
+		return &rels[i], nil
 	}
-	return &rel, nil
+	if version != "" {
+		return nil, fmt.Errorf("version v%s not found", normalizeVer(version))
+	}
+	return nil, errors.New("no release found")
 }
 
-func fetchLatestReleaseWithSource(timeout time.Duration, usedFlag updateSource) (*ghRelease, updateSource, error) {
-	if usedFlag == sourceMirror {
-		rel, err := fetchLatestReleaseFromMirror(timeout)
-		return rel, sourceMirror, err
-	}
-	if usedFlag == sourceGitHub {
-		rel, err := fetchLatestRelease(timeout)
-		return rel, sourceGitHub, err
+// fetchWithSource tries sources in order, returning the first result fetched
+func fetchWithSource[T any](sources []updateSource, fetch func(updateSource) (T, error)) (T, updateSource, error) {
+	var errs []error
+	for i, source := range sources {
+		res, err := fetch(source)
+		if err == nil {
+			return res, source, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", source, err))
+		if i < len(sources)-1 {
+			fmt.Fprintf(os.Stderr, "Source %s failed (%v), fallback to %s...\n", source, err, sources[i+1])
+		}
 	}
+	var zero T
+	return zero, "", errors.Join(errs...)
+}
 
-	rel, err := fetchLatestReleaseFromMirror(timeout)
-	if err == nil {
-		return rel, sourceMirror, nil
+// listReleases prints releases of channel, marking the current version
+func listReleases(sources []updateSource, timeout time.Duration, channel releaseChannel) error {
+	rels, used, err := fetchWithSource(sources, func(source updateSource) ([]ghRelease, error) {
+		return fetchReleases(source, timeout)
+	})
+	if err != nil {
+		return err
 	}
-	fmt.Fprintf(os.Stderr, "Mirror source failed (%v), fallback to GitHub...\n", err)
-	rel, err = fetchLatestRelease(timeout)
-	return rel, sourceGitHub, err
+	cur := normalizeVer(version)
+	fmt.Printf("Releases from %s:\n", used)
+	for _, rel := range rels {
+		if rel.Prerelease && channel == channelStable {
+			continue
+		}
+		var marks []string
+		if rel.Prerelease {
+			marks = append(marks, "prerelease")
+		}
+		if normalizeVer(rel.TagName) == cur {
+			marks = append(marks, "current")
+		}
+		fmt.Printf("  v%-16s %s\n", normalizeVer(rel.TagName), strings.Join(marks, ", "))
+	}
+	return nil
 }
 
 func targetAssetName() string {
@@ -311,6 +539,84 @@ func checksumAssetName(latest string) string {
 	return fmt.Sprintf("wg-quick-op_%s_checksums.txt", latest)
 }
 
+// verifyChecksums verifies minisign signature <checksums>.minisig of the checksums file with the embedded key.
+// Builds without a key only accept checksums from GitHub, as they are
+func verifyChecksums(rel *ghRelease, used updateSource, sumName string, sumBytes []byte, timeout time.Duration) error {
+	pk, err := publicKey()
+	if err != nil {
+		return err
+	}
+	if pk == nil {
+		if !used.trusted() {
+			return fmt.Errorf("signature is mandatory for source %s, but this build has no release public key", used)
+		}
+		fmt.Fprintf(os.Stderr, "WARN: signature of %s not verified, no release public key in this build\n", sumName)
+		return nil
+	}
+	sigURL, err := setAssetURL(rel, used, sumName+".minisig")
+	if err != nil {
+		return fmt.Errorf("signature of checksums is required: %w", err)
+	}
+	sigBytes, err := downloadToBytes(sigURL, timeout, 4<<10)
+	if err != nil {
+		return fmt.Errorf("download signature failed: %w", err)
+	}
+	return verifySignature(pk, sumBytes, sigBytes, fmt.Sprintf("%s from %s", sumName, used))
+}
+
+func verifySignature(pk *minisign.PublicKey, sumBytes, sigBytes []byte, what string) error {
+	sig, err := minisign.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("parse signature failed: %w", err)
+	}
+	if err := pk.Verify(sumBytes, sig); err != nil {
+		return fmt.Errorf("signature verification of %s failed, refusing to update: %w", what, err)
+	}
+	fmt.Printf("Signature verified (%s)\n", sig.TrustedComment)
+	return nil
+}
+
+// verifyLocalArchive verifies the archive against the checksums file, whose signature <checksums>.minisig
+// is verified with the embedded key if the build has one. It returns the version in the checksums file name and the expected SHA256
+func verifyLocalArchive(archive, checksums string) (string, string, error) {
+	if checksums == "" {
+		matches, _ := filepath.Glob(filepath.Join(filepath.Dir(archive), checksumAssetName("*")))
+		if len(matches) != 1 {
+			return "", "", fmt.Errorf("found %d checksums files next to %s, specify it by --checksums", len(matches), archive)
+		}
+		checksums = matches[0]
+	}
+	ver, ok := strings.CutPrefix(filepath.Base(checksums), "wg-quick-op_")
+	if ver, ok = strings.CutSuffix(ver, "_checksums.txt"); !ok || ver == "" {
+		return "", "", fmt.Errorf("cannot get version from %s, expected %s", checksums, checksumAssetName("<version>"))
+	}
+
+	sumBytes, err := os.ReadFile(checksums)
+	if err != nil {
+		return "", "", fmt.Errorf("read checksums failed: %w", err)
+	}
+	pk, err := publicKey()
+	if err != nil {
+		return "", "", err
+	}
+	if pk != nil {
+		sigBytes, err := os.ReadFile(checksums + ".minisig")
+		if err != nil {
+			return "", "", fmt.Errorf("signature of checksums is required: %w", err)
+		}
+		if err := verifySignature(pk, sumBytes, sigBytes, checksums); err != nil {
+			return "", "", err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "WARN: signature of %s not verified, no release public key in this build\n", checksums)
+	}
+	expected, err := expectedSHAFromChecksumsBytes(sumBytes, filepath.Base(archive))
+	if err != nil {
+		return "", "", err
+	}
+	return normalizeVer(ver), expected, nil
+}
+
 func downloadToBytes(url string, timeout time.Duration, limit int64) ([]byte, error) {
 	client := &http.Client{Timeout: timeout}
 	req, err := http.NewRequest("GET", url, nil)
@@ -405,9 +711,27 @@ func streamExtractVerifyTarGzToPath(url, outPath, expectedSHA string, timeout ti
 		return fmt.Errorf("download error: %s: %s", resp.Status, string(b))
 	}
 
+	return extractVerifyTarGz(resp.Body, resp.ContentLength, outPath, expectedSHA)
+}
+
+// extractVerifyTarGzFile extracts binary from the local tar.gz to outPath, verifying its SHA256
+func extractVerifyTarGzFile(archive, outPath, expectedSHA string) error {
+	fp, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("open archive failed: %w", err)
+	}
+	defer fp.Close()
+	var size int64 = -1
+	if fi, err := fp.Stat(); err == nil {
+		size = fi.Size()
+	}
+	return extractVerifyTarGz(fp, size, outPath, expectedSHA)
+}
+
+func extractVerifyTarGz(r io.Reader, total int64, outPath, expectedSHA string) error {
 	pr := &progressReader{
-		r:         resp.Body,
-		total:     resp.ContentLength,
+		r:         r,
+		total:     total,
 		lastPrint: time.Now().Add(-time.Hour),
 		tty:       isTTY(),
 	}
@@ -503,10 +827,6 @@ func findAsset(rel *ghRelease, name string) (string, bool) {
 	return "", false
 }
 
-func mirrorLatestURL(name string) string {
-	return mirrorBase + "/latest/" + name
-}
-
 func isTTY() bool {
 	fi, err := os.Stdout.Stat()
 	if err != nil {
@@ -520,8 +840,11 @@ func setAssetURL(rel *ghRelease, used updateSource, name string) (string, error)
 	if !ok {
 		return "", fmt.Errorf("release asset not found: %s", name)
 	}
-	if used == sourceMirror {
-		return mirrorLatestURL(name), nil
+	if base, ok := used.base(); ok {
+		if rel.latest {
+			return base + "/latest/" + name, nil
+		}
+		return base + "/download/" + rel.TagName + "/" + name, nil
 	}
 	if u == "" {
 		return "", fmt.Errorf("asset url empty: %s", name)
@@ -544,17 +867,10 @@ func printProgress(done, total int64) {
 	}
 }
 
-func commandExists(name string) bool {
-	_, err := exec.LookPath(name)
-	return err == nil
-}
-
 func trySyncServiceScripts(newBin string) error {
 	// Detect installed service files
-	openwrt := fileExists("/etc/init.d/wg-quick-op")
-	systemdUnit := fileExists("/etc/systemd/system/wg-quick-op.service") || fileExists("/lib/systemd/system/wg-quick-op.service")
-
-	if !openwrt && !systemdUnit {
+	initSys := daemon.InstalledInit()
+	if initSys == "" {
 		if updateSyncServiceStrict {
 			return fmt.Errorf("no service scripts detected, nothing to sync")
 		}
@@ -562,15 +878,59 @@ func trySyncServiceScripts(newBin string) error {
 		return nil
 	}
 
-	out, err := exec.Command(newBin, "install").CombinedOutput()
+	// the service is running, only rewrite the files, keeping params recorded in them
+	var args []string
+	params, err := daemon.ReadServiceParams(initSys)
 	if err != nil {
-		return fmt.Errorf("sync service scripts failed: %w (output: %s)", err, strings.TrimSpace(string(out)))
+		fmt.Fprintf(os.Stderr, "WARN: %v, service scripts are synced with defaults\n", err)
+		args = []string{"install"}
+	} else {
+		args = []string{"-c", params.Config}
+		if params.LogLevel != "" {
+			args = append(args, "--log-level", params.LogLevel)
+		}
+		args = append(args, "install", "--binary", params.Binary)
 	}
-
-	// systemd: daemon-reload（if systemctl exists）
-	if commandExists("systemctl") && systemdUnit {
-		_ = exec.Command("systemctl", "daemon-reload").Run()
+	args = append(args, "--init", initSys, "--no-enable")
+	if fileExists(daemon.SystemdTemplatePath) {
+		args = append(args, "--template")
+	}
+	if params != nil && len(params.ExtraArgs) > 0 {
+		args = append(append(args, "--"), params.ExtraArgs...)
+	}
+	out, err := exec.Command(newBin, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sync service scripts failed: %w (output: %s)", err, strings.TrimSpace(string(out)))
 	}
 
 	return nil
 }
+
+// restartOrRollback restarts the installed service with the new binary at target, and waits for it to be healthy.
+// On failure, keepFailed (if set) keeps the new binary elsewhere, then the binary at oldPath is moved back
+// over it and the service restarted again
+func restartOrRollback(target, oldPath string, timeout time.Duration, keepFailed func() error) error {
+	initSys := daemon.InstalledInit()
+	if initSys == "" {
+		fmt.Println("no service installed, please restart new service manually")
+		return nil
+	}
+	fmt.Printf("restarting %s service...\n", initSys)
+	err := daemon.RestartHealthy(initSys, timeout)
+	if err == nil {
+		fmt.Println("service is healthy")
+		return nil
+	}
+	if keepFailed != nil {
+		if kerr := keepFailed(); kerr != nil {
+			return fmt.Errorf("restart new service failed: %w , keep it before fallback failed: %w", err, kerr)
+		}
+	}
+	if rerr := os.Rename(oldPath, target); rerr != nil {
+		return fmt.Errorf("restart new service failed: %w , fallback failed: %w", err, rerr)
+	}
+	if e := daemon.RestartHealthy(initSys, timeout); e != nil {
+		return fmt.Errorf("restart new service failed: %w ,fallback success ,restart old service failed: %w", err, e)
+	}
+	return fmt.Errorf("restart new service failed: %w ,fallback success ,Restart old service success", err)
+}
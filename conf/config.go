@@ -5,7 +5,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -19,19 +18,28 @@ var DDNS struct {
 	IfaceOnly      []string
 	IfaceSkip      []string
 	HandleShakeMax time.Duration
+	ProbeTimeout   time.Duration
 }
 
 var StartOnBoot struct {
-	Enabled   bool
-	IfaceOnly []string
-	IfaceSkip []string
+	Enabled    bool
+	DownOnStop bool
+	IfaceOnly  []string
+	IfaceSkip  []string
 }
 
 var EnhancedDNS struct {
+	Timeout        time.Duration
+	Retry          int
+	RateLimit      int
 	DirectResolver struct {
 		Enabled   bool
 		ROAFinder []string
 	}
+	DNSSEC struct {
+		Enabled     bool
+		TrustAnchor []string
+	}
 }
 
 // Wireguard used to change default value of Wireguard
@@ -40,10 +48,49 @@ var Wireguard struct {
 	RandomPort bool
 }
 
+var OpenWrt struct {
+	UCIIface bool
+	NameMap  map[string]string
+	WAN      []string
+	Firewall struct {
+		Default string
+		FwMap   map[string]string
+	}
+}
+
+var Cost struct {
+	Enabled   bool
+	Alpha     float64
+	Threshold float64
+	Fallback  int
+	Bird      string
+	JSON      string
+	Hook      string
+}
+
+var Bird struct {
+	Output   string
+	Template string
+	Auto     bool
+	Reload   string
+}
+
+var Update struct {
+	Sources []string
+	Keep    int
+	Auto    bool
+	Window  string
+	Jitter  time.Duration
+	Channel string
+}
+
 var Log struct {
 	Level zerolog.Level
 }
 
+// LogLevelOverride is set by --log-level, it takes precedence over log.level
+var LogLevelOverride string
+
 func Init(file string) {
 	if _, err := os.Stat(file); err != nil {
 		if !os.IsNotExist(err) {
@@ -65,8 +112,23 @@ func Init(file string) {
 	// 先设默认值
 	viper.SetDefault("ddns.interval", 60)
 	viper.SetDefault("ddns.handshake_max", 150)
+	viper.SetDefault("ddns.probe_timeout", 2000)
+	viper.SetDefault("enhanced_dns.timeout", 500)
+	viper.SetDefault("enhanced_dns.retry", 3)
+	viper.SetDefault("enhanced_dns.rate_limit", 50)
 	viper.SetDefault("wireguard.MTU", 1420)
 	viper.SetDefault("wireguard.random_port", false)
+	viper.SetDefault("openwrt.wan", []string{"wan", "wan6"})
+	viper.SetDefault("cost.alpha", 0.3)
+	viper.SetDefault("cost.threshold", 0.2)
+	viper.SetDefault("cost.fallback", 65535)
+	viper.SetDefault("bird.output", "/etc/bird/wg-quick-op-bgp.conf")
+	viper.SetDefault("bird.template", "dnpeers")
+	viper.SetDefault("bird.reload", "birdc configure")
+	viper.SetDefault("update.keep", 3)
+	viper.SetDefault("update.window", "03:00-05:00")
+	viper.SetDefault("update.jitter", 3600)
+	viper.SetDefault("update.channel", "stable")
 	viper.SetDefault("log.level", "info")
 
 	//再读配置
@@ -75,28 +137,48 @@ func Init(file string) {
 	}
 
 	update()
+}
+
+// File returns path of the config file in use
+func File() string {
+	return viper.ConfigFileUsed()
+}
 
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		update()
-	})
-	viper.WatchConfig()
+// Reload re-reads the config file. It overwrites the package variables, so the caller must keep others from
+// reading them meanwhile, like the service does with its lock
+func Reload() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	update()
+	return nil
 }
 
 func update() {
 	DDNS.Interval = time.Duration(viper.GetInt("ddns.interval")) * time.Second
 	DDNS.HandleShakeMax = time.Duration(viper.GetInt("ddns.handshake_max")) * time.Second
+	DDNS.ProbeTimeout = time.Duration(viper.GetInt("ddns.probe_timeout")) * time.Millisecond
 	DDNS.IfaceOnly = viper.GetStringSlice("ddns.only_ifaces")
 	DDNS.IfaceSkip = viper.GetStringSlice("ddns.skip_ifaces")
 
 	StartOnBoot.Enabled = viper.GetBool("start_on_boot.enabled")
 	StartOnBoot.IfaceOnly = viper.GetStringSlice("start_on_boot.only_ifaces")
 	StartOnBoot.IfaceSkip = viper.GetStringSlice("start_on_boot.skip_ifaces")
+	StartOnBoot.DownOnStop = viper.GetBool("start_on_boot.down_on_stop")
 
+	EnhancedDNS.Timeout = time.Duration(viper.GetInt("enhanced_dns.timeout")) * time.Millisecond
+	EnhancedDNS.Retry = viper.GetInt("enhanced_dns.retry")
+	EnhancedDNS.RateLimit = viper.GetInt("enhanced_dns.rate_limit")
 	EnhancedDNS.DirectResolver.Enabled = viper.GetBool("enhanced_dns.direct_resolver.enabled")
 	EnhancedDNS.DirectResolver.ROAFinder = viper.GetStringSlice("enhanced_dns.direct_resolver.roa_finder")
+	EnhancedDNS.DNSSEC.Enabled = viper.GetBool("enhanced_dns.dnssec.enabled")
+	EnhancedDNS.DNSSEC.TrustAnchor = viper.GetStringSlice("enhanced_dns.dnssec.trust_anchor")
 
 	// 读取日志等级
 	lvlStr := viper.GetString("log.level")
+	if LogLevelOverride != "" {
+		lvlStr = LogLevelOverride
+	}
 
 	if level, err := zerolog.ParseLevel(lvlStr); err == nil {
 		Log.Level = level
@@ -110,6 +192,32 @@ func update() {
 			Msg("invalid log.level, fallback to info; valid levels: trace, debug, info, warn, error, fatal, panic")
 	}
 
+	OpenWrt.UCIIface = viper.GetBool("openwrt.uci_iface")
+	OpenWrt.NameMap = viper.GetStringMapString("openwrt.namemap")
+	OpenWrt.WAN = viper.GetStringSlice("openwrt.wan")
+	OpenWrt.Firewall.Default = viper.GetString("openwrt.firewall.default")
+	OpenWrt.Firewall.FwMap = viper.GetStringMapString("openwrt.firewall.fwmap")
+
+	Cost.Enabled = viper.GetBool("cost.enabled")
+	Cost.Alpha = viper.GetFloat64("cost.alpha")
+	Cost.Threshold = viper.GetFloat64("cost.threshold")
+	Cost.Fallback = min(max(viper.GetInt("cost.fallback"), 1), 65535)
+	Cost.Bird = viper.GetString("cost.bird")
+	Cost.JSON = viper.GetString("cost.json")
+	Cost.Hook = viper.GetString("cost.hook")
+
+	Bird.Output = viper.GetString("bird.output")
+	Bird.Template = viper.GetString("bird.template")
+	Bird.Auto = viper.GetBool("bird.auto")
+	Bird.Reload = viper.GetString("bird.reload")
+
+	Update.Sources = viper.GetStringSlice("update.sources")
+	Update.Keep = viper.GetInt("update.keep")
+	Update.Auto = viper.GetBool("update.auto")
+	Update.Window = viper.GetString("update.window")
+	Update.Jitter = time.Duration(viper.GetInt("update.jitter")) * time.Second
+	Update.Channel = viper.GetString("update.channel")
+
 	Wireguard.MTU = viper.GetInt("wireguard.MTU")
 	Wireguard.RandomPort = viper.GetBool("wireguard.random_port")
 }
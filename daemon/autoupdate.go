@@ -0,0 +1,252 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	UpdateUpToDate   = "up-to-date"
+	UpdateRestarting = "restarting"
+	UpdateUpdated    = "updated"
+	UpdateFailed     = "failed"
+)
+
+// UpdateStatus is the result of the last automatic update, carried over the restart it causes
+type UpdateStatus struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Result    string    `json:"result"`
+	Version   string    `json:"version,omitempty"`
+	// From is the version before, set when the update restarts the service
+	From  string    `json:"from,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Next  time.Time `json:"next,omitempty"`
+}
+
+// updateWindow is a daily window, as offsets from midnight, which may cross midnight
+type updateWindow struct {
+	start  time.Duration
+	length time.Duration
+}
+
+// parseWindow parses window like 03:00-05:00
+func parseWindow(s string) (updateWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return updateWindow{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+	var offsets [2]time.Duration
+	for i, v := range []string{from, to} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return updateWindow{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	length := offsets[1] - offsets[0]
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	return updateWindow{start: offsets[0], length: length}, nil
+}
+
+// window returns the window containing t, or the next one after t
+func (w updateWindow) window(t time.Time) (time.Time, time.Time) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	var start, end time.Time
+	// yesterday's window may last till today
+	for _, d := range []time.Time{day.AddDate(0, 0, -1), day, day.AddDate(0, 0, 1)} {
+		start = d.Add(w.start)
+		end = start.Add(w.length)
+		if t.Before(end) {
+			break
+		}
+	}
+	return start, end
+}
+
+// schedule picks a time in the window containing or after t, delayed randomly up to jitter
+// while staying inside the window
+func (w updateWindow) schedule(t time.Time, jitter time.Duration) (time.Time, time.Time) {
+	start, end := w.window(t)
+	if start.Before(t) {
+		start = t
+	}
+	if span := end.Sub(start); jitter > span {
+		jitter = span
+	}
+	if jitter > 0 {
+		start = start.Add(rand.N(jitter))
+	}
+	return start, end
+}
+
+// autoUpdateLoop updates the binary once in every window until ctx is done
+func (d *daemon) autoUpdateLoop(ctx context.Context) {
+	d.lock.Lock()
+	auto, window := conf.Update.Auto, conf.Update.Window
+	d.lock.Unlock()
+	if !auto {
+		return
+	}
+	w, err := parseWindow(window)
+	if err != nil {
+		log.Err(err).Msg("auto update disabled")
+		return
+	}
+
+	from := time.Now()
+	for {
+		d.lock.Lock()
+		at, end := w.schedule(from, conf.Update.Jitter)
+		channel := conf.Update.Channel
+		if d.update == nil {
+			d.update = &UpdateStatus{}
+		}
+		d.update.Next = at
+		d.lock.Unlock()
+		log.Info().Time("at", at).Msg("next auto update scheduled")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(at)):
+		}
+		d.autoUpdate(ctx, channel)
+		from = end
+	}
+}
+
+// autoUpdate runs `update` of channel with the running binary. If a service is installed, the update runs detached
+// from the service, so it survives the restart it causes, and rolls back if the new binary is not healthy
+func (d *daemon) autoUpdate(ctx context.Context, channel string) {
+	result := &UpdateStatus{CheckedAt: time.Now(), Result: UpdateFailed}
+	defer func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.update = result
+		d.writeStatus()
+	}()
+
+	binary, err := os.Executable()
+	if err != nil {
+		result.Error = err.Error()
+		log.Err(err).Msg("auto update failed")
+		return
+	}
+	before := binaryVersion(ctx, binary)
+
+	args := []string{"-c", conf.File(), "update", "--channel", channel}
+	initSys := InstalledInit()
+	if initSys == "" {
+		args = append(args, "--no-restart")
+	} else {
+		// this process is gone once the service is restarted, the new one completes the status
+		d.lock.Lock()
+		d.update = &UpdateStatus{CheckedAt: result.CheckedAt, Result: UpdateRestarting, From: before}
+		d.writeStatus()
+		d.lock.Unlock()
+	}
+	log.Info().Strs("args", args).Msg("auto update started")
+	if err := runDetached(initSys, binary, args); err != nil {
+		output, _ := os.ReadFile(UpdateLogPath)
+		result.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output)))
+		log.Err(err).Str("output", string(output)).Msg("auto update failed")
+		return
+	}
+
+	result.Version = binaryVersion(ctx, binary)
+	if result.Version == before {
+		result.Result = UpdateUpToDate
+		log.Info().Str("version", result.Version).Msg("auto update done, already latest")
+		return
+	}
+	result.Result = UpdateUpdated
+	if initSys == "" {
+		log.Warn().Str("version", result.Version).Msg("auto update done, no service installed, please restart manually")
+	}
+}
+
+// UpdateLogPath keeps output of the last automatic update
+const UpdateLogPath = "/var/run/wg-quick-op-update.log"
+
+// runDetached runs binary with args and waits for it, writing output to UpdateLogPath. With initSys set,
+// it runs outside of the service, which is left by systemd-run for systemd killing the whole cgroup,
+// or by a new session for others signaling the main process only. It's never killed by this process
+func runDetached(initSys, binary string, args []string) error {
+	out, err := os.Create(UpdateLogPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if initSys == InitSystemd {
+		// output of the unit goes to the file directly, as systemd-run is killed with the service
+		stderr := &bytes.Buffer{}
+		cmd := exec.Command("systemd-run", append([]string{
+			"--wait", "--collect", "--quiet", "--unit", "wg-quick-op-autoupdate",
+			"-p", "StandardOutput=file:" + UpdateLogPath, "-p", "StandardError=inherit", binary,
+		}, args...)...)
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%w %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if initSys != "" {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
+	return cmd.Run()
+}
+
+// binaryVersion returns version of binary printed by `version`
+func binaryVersion(ctx context.Context, binary string) string {
+	out, err := exec.CommandContext(ctx, binary, "version").Output()
+	if err != nil {
+		log.Warn().Err(err).Msgf("get version of %s failed", binary)
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "wg-quick-op v")
+}
+
+// carryUpdateStatus takes the automatic update result from status written by the previous process,
+// completing the restart it caused. The update is rolled back if this process runs the version before it
+func carryUpdateStatus(prev *Status, current func() string) *UpdateStatus {
+	if prev == nil || prev.Update == nil || prev.Update.CheckedAt.IsZero() {
+		return nil
+	}
+	update := *prev.Update
+	update.Next = time.Time{}
+	switch update.Result {
+	case UpdateRestarting:
+		if cur := current(); cur != update.From {
+			update.Result, update.Version = UpdateUpdated, cur
+			log.Info().Str("version", update.Version).Msg("restarted after auto update")
+		} else {
+			update.Result = UpdateFailed
+			update.Error = fmt.Sprintf("new version was not healthy, rolled back to v%s, see %s", cur, UpdateLogPath)
+			log.Error().Str("version", cur).Msg("auto update rolled back")
+		}
+	case UpdateUpdated:
+		// the updated process crashed after started, and the update is rolled back
+		if cur := current(); update.From != "" && cur != update.Version {
+			update.Result = UpdateFailed
+			update.Error = fmt.Sprintf("v%s was not healthy, rolled back to v%s, see %s", update.Version, cur, UpdateLogPath)
+			log.Error().Str("version", cur).Msg("auto update rolled back")
+		}
+	}
+	return &update
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateWindow(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 5, day, hour, min, 0, 0, time.UTC)
+	}
+
+	w, err := parseWindow("03:00-05:00")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		now        time.Time
+		start, end time.Time
+	}{
+		{at(10, 1, 0), at(10, 3, 0), at(10, 5, 0)},
+		{at(10, 4, 0), at(10, 3, 0), at(10, 5, 0)},
+		{at(10, 5, 0), at(11, 3, 0), at(11, 5, 0)},
+	} {
+		start, end := w.window(c.now)
+		assert.Equal(t, c.start, start, c.now)
+		assert.Equal(t, c.end, end, c.now)
+	}
+
+	// crossing midnight
+	w, err = parseWindow("23:30 - 01:00")
+	require.NoError(t, err)
+	start, end := w.window(at(10, 0, 30))
+	assert.Equal(t, at(9, 23, 30), start)
+	assert.Equal(t, at(10, 1, 0), end)
+	start, end = w.window(at(10, 12, 0))
+	assert.Equal(t, at(10, 23, 30), start)
+	assert.Equal(t, at(11, 1, 0), end)
+
+	// jitter stays inside the window
+	for range 100 {
+		next, end := w.schedule(at(10, 0, 50), time.Hour)
+		assert.False(t, next.Before(at(10, 0, 50)))
+		assert.True(t, next.Before(end))
+	}
+
+	for _, bad := range []string{"", "03:00", "3-5", "25:00-01:00"} {
+		_, err := parseWindow(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCarryUpdateStatus(t *testing.T) {
+	checked := time.Date(2024, 5, 10, 3, 20, 0, 0, time.UTC)
+	carry := func(update UpdateStatus, current string) *UpdateStatus {
+		update.CheckedAt = checked
+		return carryUpdateStatus(&Status{Update: &update}, func() string { return current })
+	}
+
+	assert.Nil(t, carryUpdateStatus(&Status{}, nil))
+
+	// restarted by the update into the new version
+	u := carry(UpdateStatus{Result: UpdateRestarting, From: "1.0.0", Next: checked}, "1.1.0")
+	assert.Equal(t, UpdateUpdated, u.Result)
+	assert.Equal(t, "1.1.0", u.Version)
+	assert.True(t, u.Next.IsZero())
+
+	// the new version was not healthy, and the old one is back
+	u = carry(UpdateStatus{Result: UpdateRestarting, From: "1.0.0"}, "1.0.0")
+	assert.Equal(t, UpdateFailed, u.Result)
+	assert.Contains(t, u.Error, "rolled back to v1.0.0")
+	u = carry(UpdateStatus{Result: UpdateUpdated, From: "1.0.0", Version: "1.1.0"}, "1.0.0")
+	assert.Equal(t, UpdateFailed, u.Result)
+
+	// restarted later for other reasons
+	u = carry(UpdateStatus{Result: UpdateUpdated, From: "1.0.0", Version: "1.1.0"}, "1.1.0")
+	assert.Equal(t, UpdateUpdated, u.Result)
+	u = carry(UpdateStatus{Result: UpdateUpToDate, Version: "1.1.0"}, "1.1.0")
+	assert.Equal(t, UpdateUpToDate, u.Result)
+}
@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/bird"
+	"github.com/dn-11/wg-quick-op/quick"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// BirdSessions reads BGP sessions of peers with ASN set in all configs in /etc/wireguard
+func BirdSessions() []bird.Session {
+	var sessions []bird.Session
+	for _, iface := range utils.FindIface(nil, nil) {
+		// peers of interfaces with LinkLocal = auto are expected to derive theirs from keys as well,
+		// a fixed LinkLocal says nothing about addresses of peers
+		derive, err := deriveLinkLocal(iface)
+		if err != nil {
+			log.Err(err).Str("iface", iface).Msg("failed to read config, skip")
+			continue
+		}
+		options, err := quick.GetPeerOptions(iface)
+		if err != nil {
+			log.Err(err).Str("iface", iface).Msg("failed to get peer options, skip")
+			continue
+		}
+		for key, opts := range options {
+			if opts["ASN"] == "" {
+				continue
+			}
+			asn, err := strconv.ParseUint(opts["ASN"], 10, 32)
+			if err != nil {
+				log.Err(err).Str("iface", iface).Str("peer", key.String()).Msg("invalid ASN, skip")
+				continue
+			}
+			neighbor := neighborIP(opts["AllowedIPs"])
+			if derive && !neighbor.IsLinkLocalUnicast() {
+				neighbor = quick.LinkLocalFromKey(key).IP
+			}
+			if neighbor == nil {
+				log.Warn().Str("iface", iface).Str("peer", key.String()).Msg("no host route in AllowedIPs as BGP neighbor, skip")
+				continue
+			}
+			sessions = append(sessions, bird.Session{
+				Iface:    iface,
+				Peer:     key.String(),
+				Neighbor: neighbor,
+				ASN:      uint32(asn),
+			})
+		}
+	}
+	return sessions
+}
+
+// deriveLinkLocal reports whether the interface has LinkLocal = auto, reading only [Interface]
+// so nothing is resolved
+func deriveLinkLocal(iface string) (bool, error) {
+	b, err := os.ReadFile(filepath.Join("/etc/wireguard", iface+".conf"))
+	if err != nil {
+		return false, err
+	}
+	cfg := &quick.Config{}
+	if err := cfg.UnmarshalTextNoPeer(b); err != nil {
+		return false, err
+	}
+	return cfg.LinkLocal == "auto", nil
+}
+
+// neighborIP picks the peer's address from host routes in AllowedIPs, preferring IPv6 link-local
+func neighborIP(allowedIPs string) net.IP {
+	var neighbor net.IP
+	for _, addr := range strings.Split(allowedIPs, ",") {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(addr))
+		if err != nil {
+			continue
+		}
+		if ones, bits := n.Mask.Size(); ones != bits {
+			continue
+		}
+		if n.IP.IsLinkLocalUnicast() {
+			return n.IP
+		}
+		if neighbor == nil {
+			neighbor = n.IP
+		}
+	}
+	return neighbor
+}
+
+// GenerateBird writes BGP sessions to bird.output, returning whether it's changed
+func GenerateBird() (bool, error) {
+	b, err := bird.Render(BirdSessions(), conf.Bird.Template)
+	if err != nil {
+		return false, err
+	}
+	if old, err := os.ReadFile(conf.Bird.Output); err == nil && bytes.Equal(old, b) {
+		return false, nil
+	}
+	if err := writeFileAtomic(conf.Bird.Output, b); err != nil {
+		return false, fmt.Errorf("write %s failed: %w", conf.Bird.Output, err)
+	}
+	return true, nil
+}
+
+// ReloadBird runs bird.reload to apply the generated sessions
+func ReloadBird() error {
+	return reloadBird(conf.Bird.Reload)
+}
+
+func reloadBird(command string) error {
+	if command == "" {
+		return nil
+	}
+	output, exitCode, err := utils.RunCommand("sh", "-c", command)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// regenerateBird regenerates BGP sessions if bird.auto, for configs changed under /etc/wireguard. Sessions are
+// generated under d.lock as config is read, BIRD is reloaded after unlocking if they changed
+func (d *daemon) regenerateBird() {
+	d.lock.Lock()
+	if !conf.Bird.Auto {
+		d.lock.Unlock()
+		return
+	}
+	changed, err := GenerateBird()
+	output, reload := conf.Bird.Output, conf.Bird.Reload
+	d.lock.Unlock()
+
+	if err != nil {
+		log.Err(err).Msg("generate bird sessions failed")
+		return
+	}
+	if !changed {
+		return
+	}
+	log.Info().Str("output", output).Msg("bird sessions regenerated")
+	if err := reloadBird(reload); err != nil {
+		log.Err(err).Msg("reload bird failed")
+	}
+}
@@ -0,0 +1,15 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeighborIP(t *testing.T) {
+	assert.Equal(t, net.ParseIP("172.16.0.1").To4(), neighborIP("172.16.0.0/24, 172.16.0.1/32, 10.0.0.0/8"))
+	assert.Equal(t, net.ParseIP("fe80::1"), neighborIP("172.16.0.1/32, fe80::1/128, fd00::1/128"))
+	assert.Nil(t, neighborIP("0.0.0.0/0, ::/0"))
+	assert.Nil(t, neighborIP(""))
+}
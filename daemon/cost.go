@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/cost"
+	"github.com/dn-11/wg-quick-op/lib/probe"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// rttProbe pings the first host route in AllowedIPs of peers without Probe, for costs only
+func rttProbe(peer wgtypes.PeerConfig) *probe.Probe {
+	for _, allowed := range peer.AllowedIPs {
+		if ones, bits := allowed.Mask.Size(); ones == bits {
+			return &probe.Probe{Proto: probe.ICMP, Addr: allowed.IP.String()}
+		}
+	}
+	return nil
+}
+
+// rttSamples returns RTT of peers measured by their probes in this round
+func rttSamples(results map[wgtypes.Key]*probeResult) map[wgtypes.Key]time.Duration {
+	samples := make(map[wgtypes.Key]time.Duration)
+	for key, result := range results {
+		if result != nil && result.err == nil {
+			samples[key] = result.rtt
+		}
+	}
+	return samples
+}
+
+// exportCost writes costs when they change significantly, and returns cost.hook to run then, which the caller
+// should run after unlocking d.lock. caller should hold d.lock
+func (d *daemon) exportCost() string {
+	peers := make(map[string][]string)
+	for name, ddns := range d.runIfaces {
+		for _, peer := range ddns.cfg.Peers {
+			peers[name] = append(peers[name], peer.PublicKey.String())
+		}
+	}
+	d.cost.SetPeers(peers)
+	if !d.cost.Changed(conf.Cost.Threshold, conf.Cost.Fallback) {
+		return ""
+	}
+	costs, err := d.cost.Export(conf.Cost.Fallback)
+	if err != nil {
+		log.Err(err).Msg("export costs failed")
+		return ""
+	}
+
+	if conf.Cost.Bird != "" {
+		if err := writeFileAtomic(conf.Cost.Bird, cost.Bird(costs)); err != nil {
+			log.Err(err).Msg("write bird costs failed")
+		}
+	}
+	if conf.Cost.JSON != "" {
+		b, err := cost.JSON(costs)
+		if err == nil {
+			err = writeFileAtomic(conf.Cost.JSON, b)
+		}
+		if err != nil {
+			log.Err(err).Msg("write json costs failed")
+		}
+	}
+	log.Info().Int("peers", len(costs)).Msg("costs exported")
+	return conf.Cost.Hook
+}
+
+// runCostHook runs cost.hook after costs are exported
+func runCostHook(hook string) {
+	if hook == "" {
+		return
+	}
+	output, exitCode, err := utils.RunCommand("sh", "-c", hook)
+	if err != nil || exitCode != 0 {
+		log.Warn().Err(err).Int("exitCode", exitCode).Str("output", output).Msg("cost hook failed")
+	}
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,12 +1,18 @@
 package daemon
 
 import (
+	"context"
+	"maps"
+	"os"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/cost"
 	"github.com/dn-11/wg-quick-op/lib/dns"
+	"github.com/dn-11/wg-quick-op/lib/sdnotify"
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/dn-11/wg-quick-op/utils"
 	"github.com/rs/zerolog/log"
@@ -16,17 +22,34 @@ import (
 type daemon struct {
 	runIfaces     map[string]*ddns
 	pendingIfaces []string
+	update        *UpdateStatus
+	cost          *cost.Tracker
 	lock          sync.Mutex
+
+	// heartbeat is when the work loop of Run was last seen alive, in unix nanoseconds
+	heartbeat atomic.Int64
 }
 
 func newDaemon() *daemon {
 	d := &daemon{}
 	d.runIfaces = make(map[string]*ddns)
+	d.cost = cost.NewTracker()
+	if prev, err := ReadStatus(); err == nil {
+		d.update = carryUpdateStatus(prev, func() string {
+			binary, err := os.Executable()
+			if err != nil {
+				return ""
+			}
+			return binaryVersion(context.Background(), binary)
+		})
+	}
 	return d
 }
 
-func (d *daemon) Run() {
-	// prepare config
+// loadIfaces (re)initializes ddns config of all interfaces, caller should hold d.lock
+func (d *daemon) loadIfaces() {
+	d.runIfaces = make(map[string]*ddns)
+	d.pendingIfaces = nil
 	for _, iface := range utils.FindIface(conf.DDNS.IfaceOnly, conf.DDNS.IfaceSkip) {
 		log.Info().Str("iface", iface).Msg("find iface, init ddns config")
 		ddns, err := newDDNS(iface)
@@ -37,35 +60,92 @@ func (d *daemon) Run() {
 		}
 		d.runIfaces[iface] = ddns
 	}
+}
+
+// Reload re-reads config and re-initializes all interfaces
+func (d *daemon) Reload() {
+	log.Info().Msg("reloading")
+	// config is read by other goroutines under d.lock as well
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := conf.Reload(); err != nil {
+		log.Err(err).Msg("reload config failed, keep the old one")
+	}
+	d.loadIfaces()
+	d.writeStatus()
+	log.Info().Msg("reloaded")
+}
+
+// Run re-resolves endpoints periodically until ctx is done
+func (d *daemon) Run(ctx context.Context) {
+	d.lock.Lock()
+	d.loadIfaces()
+	d.writeStatus()
+	interval := conf.DDNS.Interval
+	d.lock.Unlock()
+
+	d.regenerateBird()
 
-	d.registerWatch()
-	go d.updateLoop()
+	var wg sync.WaitGroup
+	wg.Go(func() { d.registerWatch(ctx) })
+	wg.Go(func() { d.updateLoop(ctx) })
+	wg.Go(func() { d.autoUpdateLoop(ctx) })
+	defer wg.Wait()
 
+	// the loop beats whenever it's idle, so the watchdog only starves once a round is stuck
+	d.beat()
+	beat := time.NewTicker(heartbeatInterval)
+	defer beat.Stop()
+	if watchdogInterval, ok := sdnotify.WatchdogInterval(); ok {
+		wg.Go(func() { d.watchdog(ctx, watchdogInterval) })
+	}
+
+	next := time.After(interval)
 	for {
-		time.Sleep(conf.DDNS.Interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-beat.C:
+			d.beat()
+			continue
+		case <-next:
+		}
+		rounds := d.probeAll(ctx)
 		d.lock.Lock()
-		for _, iface := range d.runIfaces {
-			peers, err := quick.PeerStatus(iface.name)
-			if err != nil {
-				log.Err(err).Str("iface", iface.name).Msg("failed to get device")
+		for _, round := range rounds {
+			iface := round.iface
+			// reloaded while probing
+			if d.runIfaces[iface.name] != iface {
+				continue
+			}
+			if round.err != nil {
+				log.Err(round.err).Str("iface", iface.name).Msg("failed to get device")
 				continue
 			}
+			peers := round.peers
 
 			wgUnLink := false
+			healthy := iface.checkHealth(peers, round.results)
+			if conf.Cost.Enabled {
+				for key, rtt := range rttSamples(round.results) {
+					d.cost.Observe(iface.name, key.String(), rtt, conf.Cost.Alpha)
+				}
+			}
 
 			for _, peer := range peers {
+				iface.checkPeerState(peer, healthy[peer.PublicKey])
 				endpoint, ok := iface.unresolvedEndpoints[peer.PublicKey]
 				if !ok {
 					log.Debug().Str("iface", iface.name).Str("peer", peer.PublicKey.String()).Msg("peer endpoint is nil, skip it")
 					continue
 				}
-				if time.Since(peer.LastHandshakeTime) < conf.DDNS.HandleShakeMax {
+				if healthy[peer.PublicKey] {
 					log.Debug().Str("iface", iface.name).Str("peer", peer.PublicKey.String()).Msg("peer ok")
 					continue
 				}
-				log.Debug().Str("iface", iface.name).Str("peer", peer.PublicKey.String()).Msg("peer handshake timeout")
+				log.Debug().Str("iface", iface.name).Str("peer", peer.PublicKey.String()).Msg("peer handshake timeout or probe failed")
 				wgUnLink = true
-				addr, err := dns.ResolveUDPAddr("", endpoint)
+				addr, err := dns.ResolveEndpoint(endpoint)
 				if err != nil {
 					log.Err(err).Str("iface", iface.name).Str("peer", peer.PublicKey.String()).Msg("failed to resolve endpoint")
 					continue
@@ -102,46 +182,115 @@ func (d *daemon) Run() {
 
 			log.Info().Str("iface", iface.name).Msg("re-resolve done")
 		}
+		var hook string
+		if conf.Cost.Enabled {
+			hook = d.exportCost()
+		}
+		d.writeStatus()
+		next = time.After(conf.DDNS.Interval)
 		d.lock.Unlock()
+		// the hook may take long, e.g. reloading BIRD, so it runs without d.lock
+		runCostHook(hook)
+		d.beat()
 		log.Info().Msg("endpoint re-resolve done")
 	}
 }
 
-func (d *daemon) registerWatch() {
-	go (&WireguardWatcher{
-		UpdateCallback: func(name string) {
-			if conf.DDNS.IfaceOnly != nil && slices.Index(conf.DDNS.IfaceOnly, name) == -1 {
-				return
+// heartbeatInterval is how often the idle work loop beats
+const heartbeatInterval = 5 * time.Second
+
+func (d *daemon) beat() {
+	d.heartbeat.Store(time.Now().UnixNano())
+}
+
+// alive reports whether the work loop has beaten within timeout before now
+func (d *daemon) alive(now time.Time, timeout time.Duration) bool {
+	return now.Sub(time.Unix(0, d.heartbeat.Load())) < timeout
+}
+
+// watchdog sends Watchdog every interval until ctx is done, as long as the work loop is alive. It's sent from
+// its own goroutine, so a round taking longer than interval doesn't get the service killed, only a stuck one
+// does: pings stop once the loop missed beats for the whole watchdog timeout, which is twice interval
+func (d *daemon) watchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !d.alive(now, 2*interval) {
+				log.Warn().Time("heartbeat", time.Unix(0, d.heartbeat.Load())).Msg("work loop stuck, watchdog not fed")
+				continue
 			}
-			if conf.DDNS.IfaceSkip != nil && slices.Index(conf.DDNS.IfaceSkip, name) != -1 {
-				return
+			notify(sdnotify.Watchdog)
+		}
+	}
+}
+
+// probeAll gets peers of all running interfaces and probes them concurrently, without holding d.lock,
+// so dead tunnels waiting for probe timeouts don't block reload, watchers and the watchdog
+func (d *daemon) probeAll(ctx context.Context) []*probeRound {
+	d.lock.Lock()
+	ifaces := slices.Collect(maps.Values(d.runIfaces))
+	withRTT, timeout := conf.Cost.Enabled, conf.DDNS.ProbeTimeout
+	d.lock.Unlock()
+
+	rounds := make([]*probeRound, len(ifaces))
+	var wg sync.WaitGroup
+	for i, iface := range ifaces {
+		wg.Go(func() {
+			round := &probeRound{iface: iface}
+			round.peers, round.err = quick.PeerStatus(iface.name)
+			if round.err == nil {
+				round.results = iface.runProbes(ctx, round.peers, withRTT, timeout)
 			}
-			log.Info().Str("iface", name).Msg("iface update, add to pending list")
+			rounds[i] = round
+		})
+	}
+	wg.Wait()
+	return rounds
+}
+
+func (d *daemon) registerWatch(ctx context.Context) {
+	(&WireguardWatcher{
+		UpdateCallback: func(name string) {
+			d.regenerateBird()
 			d.lock.Lock()
 			defer d.lock.Unlock()
+			if !selected(name) {
+				return
+			}
+			log.Info().Str("iface", name).Msg("iface update, add to pending list")
 			if slices.Index(d.pendingIfaces, name) == -1 {
 				d.pendingIfaces = append(d.pendingIfaces, name)
 			}
 		},
 		RemoveCallback: func(name string) {
-			if conf.DDNS.IfaceOnly != nil && slices.Index(conf.DDNS.IfaceOnly, name) == -1 {
-				return
-			}
-			if conf.DDNS.IfaceSkip != nil && slices.Index(conf.DDNS.IfaceSkip, name) != -1 {
+			d.regenerateBird()
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			if !selected(name) {
 				return
 			}
 			log.Info().Str("iface", name).Msg("iface remove, remove from run list")
-			d.lock.Lock()
-			defer d.lock.Unlock()
 			delete(d.runIfaces, name)
 			d.pendingIfaces = slices.DeleteFunc(d.pendingIfaces, func(i string) bool {
 				return i == name
 			})
 		},
-	}).Watch()
+	}).Watch(ctx)
+}
+
+// selected reports whether the interface is selected by ddns.iface_only and ddns.iface_skip, caller should hold d.lock
+func selected(name string) bool {
+	if conf.DDNS.IfaceOnly != nil && slices.Index(conf.DDNS.IfaceOnly, name) == -1 {
+		return false
+	}
+	return conf.DDNS.IfaceSkip == nil || slices.Index(conf.DDNS.IfaceSkip, name) == -1
 }
 
-func (d *daemon) updateLoop() {
+func (d *daemon) updateLoop(ctx context.Context) {
 	for {
 		d.lock.Lock()
 		var deleteList []string
@@ -160,7 +309,12 @@ func (d *daemon) updateLoop() {
 				return i == iface
 			})
 		}
+		interval := conf.DDNS.Interval * 2
 		d.lock.Unlock()
-		time.Sleep(conf.DDNS.Interval * 2)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
 	}
 }
@@ -0,0 +1,18 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlive(t *testing.T) {
+	d := &daemon{}
+	d.beat()
+	now := time.Now()
+	assert.True(t, d.alive(now, time.Minute))
+	// a round running longer than the ping interval is still alive within the timeout
+	assert.True(t, d.alive(now.Add(50*time.Second), time.Minute))
+	assert.False(t, d.alive(now.Add(2*time.Minute), time.Minute))
+}
@@ -1,6 +1,12 @@
 package daemon
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/probe"
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/rs/zerolog/log"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -10,6 +16,18 @@ type ddns struct {
 	cfg                 *quick.Config
 	name                string
 	unresolvedEndpoints map[wgtypes.Key]string
+	// peerUp records whether each peer was healthy at last check
+	peerUp map[wgtypes.Key]bool
+	// probes are set by Probe of peers, probeResults keeps the last result of each
+	probes       map[wgtypes.Key]*probe.Probe
+	probeResults map[wgtypes.Key]*probeResult
+	// rttProbes measure RTT of peers without Probe for costs
+	rttProbes map[wgtypes.Key]*probe.Probe
+}
+
+type probeResult struct {
+	rtt time.Duration
+	err error
 }
 
 var randomPort int = 0
@@ -17,6 +35,10 @@ var randomPort int = 0
 func newDDNS(iface string) (*ddns, error) {
 	var ddnsConfig ddns
 	ddnsConfig.name = iface
+	ddnsConfig.peerUp = make(map[wgtypes.Key]bool)
+	ddnsConfig.probes = make(map[wgtypes.Key]*probe.Probe)
+	ddnsConfig.probeResults = make(map[wgtypes.Key]*probeResult)
+	ddnsConfig.rttProbes = make(map[wgtypes.Key]*probe.Probe)
 	cfg, err := quick.GetConfig(iface)
 	if err != nil {
 		return nil, err
@@ -31,5 +53,107 @@ func newDDNS(iface string) (*ddns, error) {
 		log.Err(err).Str("iface", iface).Msg("failed to get unresolved unresolved Endpoint")
 	}
 	ddnsConfig.unresolvedEndpoints = endpoints
+
+	options, err := quick.GetPeerOptions(iface)
+	if err != nil {
+		log.Err(err).Str("iface", iface).Msg("failed to get peer options")
+	}
+	for key, opts := range options {
+		if opts["Probe"] == "" {
+			continue
+		}
+		p, err := probe.Parse(opts["Probe"])
+		if err != nil {
+			log.Err(err).Str("iface", iface).Str("peer", key.String()).Msg("invalid probe, use handshake age")
+			continue
+		}
+		ddnsConfig.probes[key] = p
+	}
+	for _, peer := range cfg.Peers {
+		if _, ok := ddnsConfig.probes[peer.PublicKey]; ok {
+			continue
+		}
+		if p := rttProbe(peer); p != nil {
+			ddnsConfig.rttProbes[peer.PublicKey] = p
+		}
+	}
 	return &ddnsConfig, nil
 }
+
+// probeRound is the result of getting peers of an interface and probing them once
+type probeRound struct {
+	iface   *ddns
+	peers   map[wgtypes.Key]*wgtypes.Peer
+	results map[wgtypes.Key]*probeResult
+	err     error
+}
+
+// runProbes runs Probe of peers, and RTT probes of other peers if withRTT, all concurrently in one pass, each
+// within timeout. It only reads probes fixed since newDDNS, so it runs without holding daemon.lock
+func (d *ddns) runProbes(ctx context.Context, peers map[wgtypes.Key]*wgtypes.Peer, withRTT bool, timeout time.Duration) map[wgtypes.Key]*probeResult {
+	results := make(map[wgtypes.Key]*probeResult)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for key := range peers {
+		p, ok := d.probes[key]
+		if !ok && withRTT {
+			p, ok = d.rttProbes[key]
+		}
+		if !ok {
+			continue
+		}
+		wg.Go(func() {
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			rtt, err := p.Run(pctx, d.name)
+			if err != nil {
+				log.Debug().Err(err).Str("iface", d.name).Str("peer", key.String()).Str("probe", p.String()).Msg("probe failed")
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			results[key] = &probeResult{rtt: rtt, err: err}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// checkHealth judges health of each peer by result of its probe run over the tunnel if configured, otherwise
+// by handshake age. Results of probes are kept for status, caller should hold daemon.lock
+func (d *ddns) checkHealth(peers map[wgtypes.Key]*wgtypes.Peer, results map[wgtypes.Key]*probeResult) map[wgtypes.Key]bool {
+	healthy := make(map[wgtypes.Key]bool)
+	for key, peer := range peers {
+		if _, ok := d.probes[key]; !ok {
+			healthy[key] = time.Since(peer.LastHandshakeTime) < conf.DDNS.HandleShakeMax
+			continue
+		}
+		result := results[key]
+		d.probeResults[key] = result
+		healthy[key] = result != nil && result.err == nil
+	}
+	return healthy
+}
+
+// checkPeerState sends ubus event when the peer goes up or down
+func (d *ddns) checkPeerState(peer *wgtypes.Peer, up bool) {
+	last, ok := d.peerUp[peer.PublicKey]
+	d.peerUp[peer.PublicKey] = up
+	// first check only records the state
+	if !ok || last == up {
+		return
+	}
+	event := PeerEvent{
+		Iface:    d.name,
+		Peer:     peer.PublicKey.String(),
+		State:    "down",
+		Endpoint: d.unresolvedEndpoints[peer.PublicKey],
+	}
+	if up {
+		event.State = "up"
+	}
+	if peer.Endpoint != nil {
+		event.Resolved = peer.Endpoint.String()
+	}
+	log.Info().Str("iface", d.name).Str("peer", event.Peer).Msgf("peer %s", event.State)
+	sendEvent("peer", event)
+}
@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	_ "embed"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	InitSystemd = "systemd"
+	InitProcd   = "procd"
+	InitOpenRC  = "openrc"
+	InitRunit   = "runit"
+	InitS6      = "s6"
+)
+
+// Inits lists supported init systems
+var Inits = []string{InitSystemd, InitProcd, InitOpenRC, InitRunit, InitS6}
+
+const RunitServiceDir = "/etc/sv/wg-quick-op"
+
+// S6ServiceDir is the s6-rc service definition of s6-overlay
+const S6ServiceDir = "/etc/s6-overlay/s6-rc.d/wg-quick-op"
+const s6UserBundle = "/etc/s6-overlay/s6-rc.d/user/contents.d/wg-quick-op"
+
+//go:embed wg-quick-op-openrc
+var OpenRCServiceFile []byte
+
+//go:embed wg-quick-op-run
+var RunScriptFile []byte
+
+// runitScanDirs are where runsvdir looks for services on different distros
+var runitScanDirs = []string{"/var/service", "/etc/service", "/service"}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// DetectInit detects the running init system, falling back to procd (OpenWrt init.d)
+func DetectInit() string {
+	switch {
+	case exists("/run/systemd/system"):
+		return InitSystemd
+	case exists("/sbin/procd"):
+		return InitProcd
+	case exists("/run/openrc") || exists("/sbin/openrc-run"):
+		return InitOpenRC
+	case exists("/run/s6") || exists("/etc/s6-overlay"):
+		return InitS6
+	case exists("/run/runit") || exists("/etc/runit"):
+		return InitRunit
+	}
+	if _, err := exec.LookPath("runsvdir"); err == nil {
+		return InitRunit
+	}
+	return InitProcd
+}
+
+// InstalledInit returns the init system which wg-quick-op service is installed for, or empty if not installed
+func InstalledInit() string {
+	switch {
+	case exists(SystemdServicePath):
+		return InitSystemd
+	case exists(RunitServiceDir):
+		return InitRunit
+	case exists(S6ServiceDir):
+		return InitS6
+	case exists(InitdServicePath):
+		// both procd and OpenRC use /etc/init.d
+		if DetectInit() == InitOpenRC {
+			return InitOpenRC
+		}
+		return InitProcd
+	}
+	return ""
+}
+
+// runServiceStep runs a command to enable/start/stop the service, only logs failures
+func runServiceStep(name string, args ...string) bool {
+	cmd := append([]string{name}, args...)
+	output, exitCode, err := utils.RunCommand(name, args...)
+	if err != nil || exitCode != 0 {
+		log.Warn().Err(err).Int("exitCode", exitCode).Str("output", output).Msgf("failed to run %q", cmd)
+		return false
+	}
+	log.Info().Msgf("%q executed successfully", cmd)
+	return true
+}
+
+func addOpenRCService(params ServiceParams, enable bool) {
+	log.Info().Msg("OpenRC detected. Installing init.d service...")
+	if err := params.writeRendered(InitdServicePath, OpenRCServiceFile, 0755); err != nil {
+		log.Fatal().Err(err).Msgf("write %s failed", InitdServicePath)
+	}
+	log.Info().Msg("add wg-quick-op to OpenRC success")
+	if enable {
+		runServiceStep("rc-update", "add", "wg-quick-op", "default")
+		runServiceStep("rc-service", "wg-quick-op", "start")
+	}
+}
+
+func rmOpenRCService() {
+	log.Info().Msg("Removing OpenRC service...")
+	runServiceStep("rc-service", "wg-quick-op", "stop")
+	runServiceStep("rc-update", "del", "wg-quick-op", "default")
+	removeServiceFile(InitdServicePath)
+}
+
+func addRunitService(params ServiceParams, enable bool) {
+	log.Info().Msg("runit detected. Installing runit service...")
+	if err := os.MkdirAll(RunitServiceDir, 0755); err != nil {
+		log.Fatal().Err(err).Msgf("create %s failed", RunitServiceDir)
+	}
+	if err := params.writeRendered(filepath.Join(RunitServiceDir, "run"), RunScriptFile, 0755); err != nil {
+		log.Fatal().Err(err).Msgf("write %s failed", filepath.Join(RunitServiceDir, "run"))
+	}
+	log.Info().Msgf("add wg-quick-op to %s success", RunitServiceDir)
+	if !enable {
+		return
+	}
+
+	// runsvdir starts the service once it's linked to the scan dir
+	for _, dir := range runitScanDirs {
+		if !exists(dir) {
+			continue
+		}
+		link := filepath.Join(dir, "wg-quick-op")
+		if err := os.Symlink(RunitServiceDir, link); err != nil && !os.IsExist(err) {
+			log.Err(err).Msgf("link %s to %s failed", RunitServiceDir, link)
+			return
+		}
+		log.Info().Msgf("linked %s to %s, runsvdir will start it in seconds", RunitServiceDir, link)
+		return
+	}
+	log.Warn().Msgf("runit scan dir not found in %v, please link %s manually", runitScanDirs, RunitServiceDir)
+}
+
+func rmRunitService() {
+	log.Info().Msg("Removing runit service...")
+	runServiceStep("sv", "down", "wg-quick-op")
+	for _, dir := range runitScanDirs {
+		link := filepath.Join(dir, "wg-quick-op")
+		if target, err := os.Readlink(link); err == nil && target == RunitServiceDir {
+			removeServiceFile(link)
+		}
+	}
+	if err := os.RemoveAll(RunitServiceDir); err != nil {
+		log.Err(err).Msgf("failed to delete %s", RunitServiceDir)
+		return
+	}
+	log.Info().Msgf("removed %s", RunitServiceDir)
+}
+
+func addS6Service(params ServiceParams, enable bool) {
+	log.Info().Msg("s6 detected. Installing s6-overlay service...")
+	if err := os.MkdirAll(filepath.Join(S6ServiceDir, "dependencies.d"), 0755); err != nil {
+		log.Fatal().Err(err).Msgf("create %s failed", S6ServiceDir)
+	}
+	files := []struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{filepath.Join(S6ServiceDir, "type"), []byte("longrun\n"), 0644},
+		{filepath.Join(S6ServiceDir, "run"), RunScriptFile, 0755},
+		{filepath.Join(S6ServiceDir, "dependencies.d", "base"), nil, 0644},
+	}
+	for _, f := range files {
+		if err := params.writeRendered(f.path, f.content, f.mode); err != nil {
+			log.Fatal().Err(err).Msgf("write %s failed", f.path)
+		}
+	}
+	log.Info().Msgf("add wg-quick-op to %s success", S6ServiceDir)
+	if !enable {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s6UserBundle), 0755); err != nil {
+		log.Err(err).Msgf("create %s failed", filepath.Dir(s6UserBundle))
+		return
+	}
+	if err := os.WriteFile(s6UserBundle, nil, 0644); err != nil {
+		log.Err(err).Msgf("write %s failed", s6UserBundle)
+		return
+	}
+	// s6-rc database is compiled on container start, so the new service may be unknown until then
+	if !runServiceStep("s6-rc", "-u", "change", "wg-quick-op") {
+		log.Warn().Msg("wg-quick-op is enabled in the user bundle, it will start on next container start")
+	}
+}
+
+func rmS6Service() {
+	log.Info().Msg("Removing s6-overlay service...")
+	runServiceStep("s6-rc", "-d", "change", "wg-quick-op")
+	removeServiceFile(s6UserBundle)
+	if err := os.RemoveAll(S6ServiceDir); err != nil {
+		log.Err(err).Msgf("failed to delete %s", S6ServiceDir)
+		return
+	}
+	log.Info().Msgf("removed %s", S6ServiceDir)
+}
+
+func removeServiceFile(path string) {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			log.Info().Msgf("service file %s not found, nothing to remove.", path)
+			return
+		}
+		log.Err(err).Msgf("failed to delete service file %s", path)
+		return
+	}
+	log.Info().Msgf("removed service file %s", path)
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/quick"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/vishvananda/netlink"
+)
+
+const HotplugScriptPath = "/etc/hotplug.d/iface/99-wg-quick-op"
+
+//go:embed hotplug-wg-quick-op
+var HotplugScriptFile []byte
+
+// Resolve re-resolves all endpoints of the interface and applies them to the running device
+func Resolve(iface string) (map[string]string, error) {
+	return resync(iface, false)
+}
+
+// Kick forces all managed interfaces to re-resolve endpoints immediately, and randomizes ListenPort
+// not set by config if wireguard.random_port is enabled. It is used when WAN goes up or changes address.
+// wan is the interface triggering the kick, ignored if it is not in openwrt.wan; empty means always kick
+func Kick(wan string) {
+	if wan != "" && !slices.Contains(conf.OpenWrt.WAN, wan) {
+		log.Debug().Str("wan", wan).Msg("not a WAN interface, skip kick")
+		return
+	}
+	for _, iface := range utils.FindIface(conf.DDNS.IfaceOnly, conf.DDNS.IfaceSkip) {
+		if _, err := netlink.LinkByName(iface); err != nil {
+			log.Debug().Err(err).Str("iface", iface).Msg("interface not up, skip kick")
+			continue
+		}
+		if _, err := resync(iface, conf.Wireguard.RandomPort); err != nil {
+			log.Err(err).Str("iface", iface).Msg("kick failed")
+			continue
+		}
+		log.Info().Str("iface", iface).Msg("kicked")
+	}
+}
+
+func resync(iface string, randomizePort bool) (map[string]string, error) {
+	cfg, err := quick.GetConfig(iface)
+	if err != nil {
+		return nil, err
+	}
+	if randomizePort && cfg.ListenPort == nil {
+		cfg.ListenPort = &randomPort
+		log.Info().Str("iface", iface).Msg("randomize listen port")
+	}
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	if err := quick.SyncWireguardDevice(cfg, link, log.With().Str("iface", iface).Logger()); err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]string)
+	for _, peer := range cfg.Peers {
+		if peer.Endpoint != nil {
+			resolved[peer.PublicKey.String()] = peer.Endpoint.String()
+		}
+	}
+	log.Info().Str("iface", iface).Msg("re-resolve done")
+	return resolved, nil
+}
+
+// addHotplugScript installs the hotplug script to kick on WAN up, if hotplug.d is present
+func addHotplugScript(params ServiceParams) {
+	if _, err := os.Stat(filepath.Dir(filepath.Dir(HotplugScriptPath))); err != nil {
+		log.Debug().Msg("hotplug.d not found, skip installing hotplug script")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(HotplugScriptPath), 0755); err != nil {
+		log.Err(err).Msgf("create %s failed", filepath.Dir(HotplugScriptPath))
+		return
+	}
+	if err := params.writeRendered(HotplugScriptPath, HotplugScriptFile, 0644); err != nil {
+		log.Err(err).Msgf("write %s failed", HotplugScriptPath)
+		return
+	}
+	log.Info().Msgf("add hotplug script %s success", HotplugScriptPath)
+}
+
+func rmHotplugScript() {
+	if err := os.Remove(HotplugScriptPath); err != nil {
+		if !os.IsNotExist(err) {
+			log.Err(err).Msgf("failed to delete %s", HotplugScriptPath)
+		}
+		return
+	}
+	log.Info().Msgf("removed %s", HotplugScriptPath)
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// ServiceParams are rendered into service files, and recorded in them by a marker comment
+// so they can be preserved when service files are synced by update
+type ServiceParams struct {
+	Binary    string   `json:"binary"`
+	Config    string   `json:"config"`
+	LogLevel  string   `json:"log_level,omitempty"`
+	ExtraArgs []string `json:"extra_args,omitempty"`
+}
+
+const paramsMarker = "# wg-quick-op-params: "
+
+// Cmd returns the shell command line running wg-quick-op with args
+func (p ServiceParams) Cmd(args ...string) string {
+	return shellJoin(append([]string{p.Binary}, append(p.globalArgs(), args...)...))
+}
+
+// SystemdCmd is like Cmd, but escaped for systemd units, more args like %i could be appended as is
+func (p ServiceParams) SystemdCmd(args ...string) string {
+	return systemdJoin(append([]string{p.Binary}, append(p.globalArgs(), args...)...))
+}
+
+// ServiceArgs returns args of the service command, quoted for shell
+func (p ServiceParams) ServiceArgs() string {
+	return shellJoin(p.serviceArgs())
+}
+
+// ServiceCmd returns the shell command line running the service
+func (p ServiceParams) ServiceCmd() string {
+	return shellJoin(append([]string{p.Binary}, p.serviceArgs()...))
+}
+
+// SystemdServiceCmd returns the command line running the service, escaped for systemd units
+func (p ServiceParams) SystemdServiceCmd() string {
+	return systemdJoin(append([]string{p.Binary}, p.serviceArgs()...))
+}
+
+// Marker returns the comment line recording the params
+func (p ServiceParams) Marker() (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return paramsMarker + string(b), nil
+}
+
+func (p ServiceParams) globalArgs() []string {
+	args := []string{"-c", p.Config}
+	if p.LogLevel != "" {
+		args = append(args, "--log-level", p.LogLevel)
+	}
+	return args
+}
+
+func (p ServiceParams) serviceArgs() []string {
+	args := append(p.globalArgs(), "service")
+	return append(args, p.ExtraArgs...)
+}
+
+// safeArg matches args needing no quoting in both shell and systemd, except % for systemd
+var safeArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	if safeArg.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellAssign quotes s as the value of a shell variable assignment, in double quotes
+func shellAssign(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s) + `"`
+}
+
+// systemdQuote quotes s as a single word of a systemd command line, where % starts a specifier
+// and $ an environment variable even in quotes
+func systemdQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if safeArg.MatchString(s) {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func systemdJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = systemdQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (p ServiceParams) render(name string, text []byte) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{"assign": shellAssign}).Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeRendered renders text with params and writes it to path
+func (p ServiceParams) writeRendered(path string, text []byte, perm os.FileMode) error {
+	b, err := p.render(path, text)
+	if err != nil {
+		return fmt.Errorf("render %s failed: %w", path, err)
+	}
+	return os.WriteFile(path, b, perm)
+}
+
+// ReadServiceParams reads params recorded in the service file installed for the init system
+func ReadServiceParams(initSys string) (*ServiceParams, error) {
+	path := ServiceFilePath(initSys)
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	p, err := readParams(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func readParams(r io.Reader) (*ServiceParams, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, found := strings.CutPrefix(scanner.Text(), paramsMarker)
+		if !found {
+			continue
+		}
+		var p ServiceParams
+		if err := json.Unmarshal([]byte(line), &p); err != nil {
+			return nil, fmt.Errorf("parse params failed: %w", err)
+		}
+		return &p, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no params recorded")
+}
+
+// ServiceFilePath returns the main service file of the init system
+func ServiceFilePath(initSys string) string {
+	switch initSys {
+	case InitSystemd:
+		return SystemdServicePath
+	case InitRunit:
+		return RunitServiceDir + "/run"
+	case InitS6:
+		return S6ServiceDir + "/run"
+	default:
+		return InitdServicePath
+	}
+}
@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceParams(t *testing.T) {
+	params := ServiceParams{
+		Binary:    "/opt/bin/wg-quick-op",
+		Config:    "/opt/etc/wg.toml",
+		LogLevel:  "debug",
+		ExtraArgs: []string{"-v"},
+	}
+	for name, text := range map[string][]byte{
+		"procd":    InitdServiceFile,
+		"systemd":  SystemdServiceFile,
+		"openrc":   OpenRCServiceFile,
+		"run":      RunScriptFile,
+		"template": SystemdTemplateFile,
+		"rpcd":     RpcdPluginFile,
+		"hotplug":  HotplugScriptFile,
+	} {
+		b, err := params.render(name, text)
+		require.NoError(t, err, name)
+		assert.NotContains(t, string(b), "/etc/wg-quick-op.toml", name)
+		assert.NotContains(t, string(b), "/usr/sbin", name)
+
+		read, err := readParams(bytes.NewReader(b))
+		require.NoError(t, err, name)
+		assert.Equal(t, params, *read, name)
+	}
+
+	b, err := params.render("systemd", SystemdServiceFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\nExecStart=/opt/bin/wg-quick-op -c /opt/etc/wg.toml --log-level debug service -v\n")
+	b, err = params.render("openrc", OpenRCServiceFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\ncommand_args=\"-c /opt/etc/wg.toml --log-level debug service -v\"\n")
+
+	_, err = readParams(bytes.NewReader([]byte("#!/bin/sh\n")))
+	assert.Error(t, err)
+}
+
+func TestServiceParamsQuoting(t *testing.T) {
+	params := ServiceParams{
+		Binary:    "/opt/my bin/wg-quick-op",
+		Config:    "/opt/etc/it's wg.toml",
+		ExtraArgs: []string{"--x=$HOME;reboot", "50%", `"q"`},
+	}
+	args := []string{"-c", "/opt/etc/it's wg.toml", "service", "--x=$HOME;reboot", "50%", `"q"`}
+
+	// shell command lines are split back to the same args
+	for name, text := range map[string][]byte{
+		"procd": InitdServiceFile,
+		"run":   RunScriptFile,
+	} {
+		b, err := params.render(name, text)
+		require.NoError(t, err, name)
+		var cmd string
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if after, ok := strings.CutPrefix(line, "procd_set_param command "); ok {
+				cmd = after
+			} else if after, ok := strings.CutPrefix(line, "exec "); ok && after != "2>&1" {
+				cmd = after
+			}
+		}
+		require.NotEmpty(t, cmd, name)
+		assert.Equal(t, append([]string{params.Binary}, args...), shellSplit(t, cmd), name)
+	}
+
+	// the assignment and eval by openrc-run
+	b, err := params.render("openrc", OpenRCServiceFile)
+	require.NoError(t, err)
+	var assigns []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "command=") || strings.HasPrefix(line, "command_args=") {
+			assigns = append(assigns, line)
+		}
+	}
+	require.Len(t, assigns, 2)
+	assert.Equal(t, append([]string{params.Binary}, args...),
+		shellSplit(t, strings.Join(assigns, "; ")+`; printf '%s\n' "$command"; eval "printf '%s\n' $command_args"`))
+
+	b, err = params.render("systemd", SystemdServiceFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\nExecStart=\"/opt/my bin/wg-quick-op\" -c \"/opt/etc/it's wg.toml\" service \"--x=$$HOME;reboot\" 50%% \"\\\"q\\\"\"\n")
+	b, err = params.render("template", SystemdTemplateFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\nExecStart=\"/opt/my bin/wg-quick-op\" -c \"/opt/etc/it's wg.toml\" up %i\n")
+}
+
+// shellSplit returns words of a shell command line, or what it prints if it's a script
+func shellSplit(t *testing.T, cmd string) []string {
+	t.Helper()
+	if !strings.Contains(cmd, "printf") {
+		cmd = "printf '%s\n' " + cmd
+	}
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	require.NoError(t, err, cmd)
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+}
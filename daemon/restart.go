@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/utils"
+)
+
+// s6ServiceLive is where s6-overlay supervises the running service
+const s6ServiceLive = "/run/service/wg-quick-op"
+
+// RestartService restarts the installed service by its init system
+func RestartService(initSys string) error {
+	var steps [][]string
+	switch initSys {
+	case InitSystemd:
+		steps = [][]string{{"systemctl", "restart", "wg-quick-op"}}
+	case InitProcd:
+		steps = [][]string{{InitdServicePath, "restart"}}
+	case InitOpenRC:
+		steps = [][]string{{"rc-service", "wg-quick-op", "restart"}}
+	case InitRunit:
+		steps = [][]string{{"sv", "restart", "wg-quick-op"}}
+	case InitS6:
+		steps = [][]string{{"s6-rc", "-d", "change", "wg-quick-op"}, {"s6-rc", "-u", "change", "wg-quick-op"}}
+	default:
+		return fmt.Errorf("unknown init system %q", initSys)
+	}
+	for _, step := range steps {
+		output, exitCode, err := utils.RunCommand(step[0], step[1:]...)
+		if err != nil {
+			return fmt.Errorf("run %q failed: %w", step, err)
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("run %q failed with exit code %d: %s", step, exitCode, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// serviceActive checks whether the init system reports the service running
+func serviceActive(initSys string) bool {
+	var name string
+	var args []string
+	switch initSys {
+	case InitSystemd:
+		// Type=notify, active only after READY=1
+		name, args = "systemctl", []string{"is-active", "--quiet", "wg-quick-op"}
+	case InitProcd:
+		name, args = InitdServicePath, []string{"running"}
+	case InitOpenRC:
+		name, args = "rc-service", []string{"wg-quick-op", "status"}
+	case InitRunit:
+		output, exitCode, err := utils.RunCommand("sv", "status", "wg-quick-op")
+		return err == nil && exitCode == 0 && strings.HasPrefix(output, "run:")
+	case InitS6:
+		output, exitCode, err := utils.RunCommand("s6-svstat", "-u", s6ServiceLive)
+		return err == nil && exitCode == 0 && strings.TrimSpace(output) == "true"
+	default:
+		return false
+	}
+	_, exitCode, err := utils.RunCommand(name, args...)
+	return err == nil && exitCode == 0
+}
+
+// WaitHealthy waits until the service is reported running by its init system, and a process other
+// than oldPID has written status since the given time, which the daemon does once its interfaces are loaded
+func WaitHealthy(initSys string, oldPID int, since time.Time, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		active := serviceActive(initSys)
+		status, err := ReadStatus()
+		fresh := err == nil && status.PID != oldPID && !status.UpdatedAt.Before(since) && processAlive(status.PID)
+		if active && fresh {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			switch {
+			case !active:
+				return errors.New("service is not running")
+			case err != nil:
+				return fmt.Errorf("read status failed: %w", err)
+			default:
+				return fmt.Errorf("no status from the new process since %s", since.Format(time.RFC3339))
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func processAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// RestartHealthy restarts the service and waits for the new process to be healthy
+func RestartHealthy(initSys string, timeout time.Duration) error {
+	var oldPID int
+	if status, err := ReadStatus(); err == nil {
+		oldPID = status.PID
+	}
+	since := time.Now()
+	if err := RestartService(initSys); err != nil {
+		return err
+	}
+	return WaitHealthy(initSys, oldPID, since, timeout)
+}
@@ -4,19 +4,28 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
+	"github.com/dn-11/wg-quick-op/lib/sdnotify"
 	"github.com/dn-11/wg-quick-op/quick"
 	"github.com/dn-11/wg-quick-op/utils"
 	"github.com/rs/zerolog/log"
 
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"syscall"
 )
 
 const InitdServicePath = "/etc/init.d/wg-quick-op"
 const SystemdServicePath = "/etc/systemd/system/wg-quick-op.service"
+const SystemdTemplatePath = "/etc/systemd/system/wg-quick-op@.service"
 
 //go:embed wg-quick-op
 var InitdServiceFile []byte
@@ -24,38 +33,101 @@ var InitdServiceFile []byte
 //go:embed wg-quick-op-systemd
 var SystemdServiceFile []byte
 
-var isSystemd bool
-
-func init() {
-	if _, err := os.Stat("/run/systemd/system"); err == nil {
-		isSystemd = true
-	}
-}
+//go:embed wg-quick-op-systemd-template
+var SystemdTemplateFile []byte
 
+// Serve runs the service until SIGTERM or SIGINT, SIGHUP or changes of the config file reload config and interfaces
 func Serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	waitBoot := func() []string { return nil }
 	if conf.StartOnBoot.Enabled {
-		startOnBoot()
+		waitBoot = startOnBoot(ctx)
 	}
 
 	d := newDaemon()
-	d.Run()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	changed := make(chan struct{}, 1)
+	go watchConfig(ctx, conf.File(), func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		// interfaces brought up on boot read config without d.lock
+		waitBoot()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			case <-changed:
+			}
+			notify(sdnotify.Reloading)
+			d.Reload()
+			notify(sdnotify.Ready)
+		}
+	}()
+
+	go func() {
+		started := waitBoot()
+		// dependent units start once interfaces are up
+		notify(sdnotify.Ready, sdnotify.Status(fmt.Sprintf("%d interfaces started on boot", len(started))))
+	}()
+
+	d.Run(ctx)
+	log.Info().Msg("service stopping")
+	notify(sdnotify.Stopping)
+
+	started := waitBoot()
+	d.lock.Lock()
+	if conf.StartOnBoot.DownOnStop {
+		downOnStop(started)
+	}
+	d.lock.Unlock()
+	log.Info().Msg("service stopped")
+}
+
+// notify notifies systemd if the service is run with Type=notify
+func notify(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil {
+		log.Warn().Err(err).Strs("states", states).Msg("sd_notify failed")
+	}
 }
 
-func startOnBoot() {
+// startOnBoot ups interfaces selected by start_on_boot in background, the returned function waits
+// for them and returns the interfaces brought up by it
+func startOnBoot(ctx context.Context) func() []string {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var started []string
 	for _, iface := range utils.FindIface(conf.StartOnBoot.IfaceOnly, conf.StartOnBoot.IfaceSkip) {
+		if templateUnitEnabled(iface) {
+			log.Info().Str("iface", iface).Msgf("managed by %s, skip", templateUnit(iface))
+			continue
+		}
 		cfg, err := quick.GetConfig(iface)
 		if err != nil {
 			log.Err(err).Str("iface", iface).Msg("failed to get config")
 			continue
 		}
-		go func() {
-			if err := <-utils.GoRetryCtx(context.Background(), 5, time.Second, func(_ context.Context) error {
+		wg.Go(func() {
+			var exist bool
+			if err := <-utils.GoRetryCtx(ctx, 5, time.Second, func(ctx context.Context) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
 				err := quick.Up(cfg, iface, log.With().Str("iface", iface).Logger())
 				if err == nil {
 					return nil
 				}
 				if errors.Is(err, os.ErrExist) {
 					log.Info().Str("iface", iface).Msg("interface already up")
+					exist = true
 					return nil
 				}
 				log.Err(err).Str("iface", iface).Msg("failed to up interface, retrying...")
@@ -64,35 +136,103 @@ func startOnBoot() {
 				log.Err(err).Str("iface", iface).Msg("failed to up interface")
 				return
 			}
+			if exist {
+				return
+			}
+			lock.Lock()
+			started = append(started, iface)
+			lock.Unlock()
 			log.Info().Msgf("interface %s up", iface)
-		}()
+		})
 	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		openwrt.Reload()
+		close(done)
+	}()
 
 	log.Info().Msg("all interface parsed")
+	return func() []string {
+		<-done
+		lock.Lock()
+		defer lock.Unlock()
+		return started
+	}
+}
+
+// downOnStop downs the interfaces started by the service
+func downOnStop(ifaces []string) {
+	for _, iface := range ifaces {
+		logger := log.With().Str("iface", iface).Logger()
+		cfgs := quick.MatchConfig(regexp.QuoteMeta(iface), quick.ParseNoPeer)
+		cfg, ok := cfgs[iface]
+		if !ok {
+			logger.Error().Msg("config not found, cannot down interface")
+			continue
+		}
+		if err := quick.Down(cfg, iface, logger); err != nil {
+			logger.Err(err).Msg("failed to down interface")
+			continue
+		}
+		logger.Info().Msg("interface down")
+	}
+	openwrt.Reload()
 }
 
-func AddService() {
-	_, err := exec.LookPath("wg-quick-op")
+// AddService installs service files for the init system, which is detected if init is empty,
+// and enables and starts the service if enable is set.
+// template installs systemd template unit wg-quick-op@.service as well, and enables the instances
+// of interfaces selected by start_on_boot if enable is set.
+// Service files are rendered with params, the binary found in path is used if params.Binary is empty
+func AddService(initSys string, params ServiceParams, enable bool, template bool) {
+	binary, err := exec.LookPath("wg-quick-op")
 	if err != nil {
 		if !errors.Is(err, exec.ErrDot) {
 			log.Err(err).Msgf("look up wg-quick-up failed")
 		}
 		log.Warn().Msg("wg-quick-op hasn't been installed to path, let's turn to install it")
 		Install()
+		binary = installed
 	}
-	if isSystemd {
-		addSystemdService()
-	} else {
-		addInitdService()
+	if params.Binary == "" {
+		if params.Binary, err = filepath.Abs(binary); err != nil {
+			log.Fatal().Err(err).Msgf("get absolute path of %s failed", binary)
+		}
+	}
+	if initSys == "" {
+		initSys = DetectInit()
+	}
+	if template && initSys != InitSystemd {
+		log.Warn().Msgf("template unit is only supported by systemd, not %s", initSys)
+	}
+	switch initSys {
+	case InitSystemd:
+		addSystemdService(params, enable, template)
+	case InitProcd:
+		addInitdService(params, enable)
+	case InitOpenRC:
+		addOpenRCService(params, enable)
+	case InitRunit:
+		addRunitService(params, enable)
+	case InitS6:
+		addS6Service(params, enable)
+	default:
+		log.Fatal().Msgf("unknown init system %s, expected one of %v", initSys, Inits)
 	}
 }
 
-func addSystemdService() {
+func addSystemdService(params ServiceParams, enable bool, template bool) {
 	log.Info().Msg("systemd detected. Installing systemd service...")
-	err := os.WriteFile(SystemdServicePath, SystemdServiceFile, 0644)
+	err := params.writeRendered(SystemdServicePath, SystemdServiceFile, 0644)
 	if err != nil {
 		log.Fatal().Err(err).Msgf("failed to write systemd service file to %s", SystemdServicePath)
 	}
+	if template {
+		if err := params.writeRendered(SystemdTemplatePath, SystemdTemplateFile, 0644); err != nil {
+			log.Fatal().Err(err).Msgf("failed to write systemd template unit to %s", SystemdTemplatePath)
+		}
+	}
 
 	output, exitCode, err := utils.RunCommand("systemctl", "daemon-reload")
 	if err != nil || exitCode != 0 {
@@ -102,9 +242,37 @@ func addSystemdService() {
 	}
 
 	log.Info().Msg("successfully installed systemd service.")
+	if enable {
+		// instances go first, so the service won't up them again on start
+		if template {
+			enableTemplateUnits()
+		}
+		runServiceStep("systemctl", "enable", "--now", "wg-quick-op.service")
+	}
+}
+
+// templateUnit returns the instance of wg-quick-op@.service for the interface
+func templateUnit(iface string) string {
+	return "wg-quick-op@" + iface + ".service"
+}
+
+// templateUnitEnabled reports whether the interface is managed by its own wg-quick-op@ unit
+func templateUnitEnabled(iface string) bool {
+	return exists(filepath.Join("/etc/systemd/system/multi-user.target.wants", templateUnit(iface)))
+}
+
+// enableTemplateUnits enables and starts wg-quick-op@ units of the interfaces selected by start_on_boot
+func enableTemplateUnits() {
+	if !conf.StartOnBoot.Enabled {
+		log.Info().Msg("start_on_boot is disabled, no wg-quick-op@ unit enabled")
+		return
+	}
+	for _, iface := range utils.FindIface(conf.StartOnBoot.IfaceOnly, conf.StartOnBoot.IfaceSkip) {
+		runServiceStep("systemctl", "enable", "--now", templateUnit(iface))
+	}
 }
 
-func addInitdService() {
+func addInitdService(params ServiceParams, enable bool) {
 	log.Info().Msg("init.d detected. Installing init.d service...")
 	if _, err := os.Stat(InitdServicePath); err == nil {
 		err := os.Remove(InitdServicePath)
@@ -112,22 +280,37 @@ func addInitdService() {
 			log.Warn().Msgf("remove %s failed", InitdServicePath)
 		}
 	}
-	file, err := os.OpenFile(InitdServicePath, os.O_CREATE|os.O_RDWR, 0755)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("open %s failed", InitdServicePath)
-	}
-	defer file.Close()
-	if _, err := file.Write(InitdServiceFile); err != nil {
+	if err := params.writeRendered(InitdServicePath, InitdServiceFile, 0755); err != nil {
 		log.Fatal().Err(err).Msgf("write %s failed", InitdServicePath)
 	}
 	log.Info().Msg("add wg-quick-op to init.d success")
+
+	addRpcdPlugin(params)
+	addHotplugScript(params)
+	if enable {
+		runServiceStep(InitdServicePath, "enable")
+		runServiceStep(InitdServicePath, "start")
+	}
 }
 
-func RmService() {
-	if isSystemd {
+// RmService stops, disables and removes the service of the init system, which is detected if init is empty
+func RmService(initSys string) {
+	if initSys == "" {
+		initSys = DetectInit()
+	}
+	switch initSys {
+	case InitSystemd:
 		rmSystemdService()
-	} else {
+	case InitProcd:
 		rmInitdService()
+	case InitOpenRC:
+		rmOpenRCService()
+	case InitRunit:
+		rmRunitService()
+	case InitS6:
+		rmS6Service()
+	default:
+		log.Fatal().Msgf("unknown init system %s, expected one of %v", initSys, Inits)
 	}
 }
 
@@ -154,6 +337,15 @@ func rmSystemdService() {
 		log.Info().Msg("'systemctl stop wg-quick-op.service' executed successfully")
 	}
 
+	// interfaces are kept up as the service does
+	matches, _ := filepath.Glob("/etc/systemd/system/*.wants/wg-quick-op@*.service")
+	for _, unit := range matches {
+		runServiceStep("systemctl", "disable", filepath.Base(unit))
+	}
+	if exists(SystemdTemplatePath) {
+		removeServiceFile(SystemdTemplatePath)
+	}
+
 	if err := os.Remove(SystemdServicePath); err != nil {
 		if os.IsNotExist(err) {
 			log.Info().Msgf("service file %s not found, nothing to remove.", SystemdServicePath)
@@ -175,6 +367,9 @@ func rmSystemdService() {
 func rmInitdService() {
 	log.Info().Msg("Removing init.d service...")
 
+	rmRpcdPlugin()
+	rmHotplugScript()
+
 	output, exitCode, err := utils.RunCommand(InitdServicePath, "stop")
 	if err != nil {
 		log.Info().Err(err).Msgf("could not execute stop command, the script '%s' may have already been removed.", InitdServicePath)
@@ -183,6 +378,7 @@ func rmInitdService() {
 	} else {
 		log.Info().Msg("service stopped successfully via init.d script")
 	}
+	runServiceStep(InitdServicePath, "disable")
 
 	// On some systems, you may also need to run 'update-rc.d -f wg-quick-op remove' to clean up startup links
 	if err := os.Remove(InitdServicePath); err != nil {
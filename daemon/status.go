@@ -0,0 +1,123 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/lib/dns"
+	"github.com/dn-11/wg-quick-op/lib/sdnotify"
+	"github.com/dn-11/wg-quick-op/quick"
+	"github.com/rs/zerolog/log"
+)
+
+// StatusPath is where the running service dumps its status for `wg-quick-op status`
+const StatusPath = "/var/run/wg-quick-op.json"
+
+type Status struct {
+	PID       int                     `json:"pid"`
+	UpdatedAt time.Time               `json:"updated_at"`
+	Ifaces    map[string]*IfaceStatus `json:"ifaces"`
+	Update    *UpdateStatus           `json:"update,omitempty"`
+}
+
+type IfaceStatus struct {
+	Peers []*PeerStatus `json:"peers"`
+}
+
+type PeerStatus struct {
+	PublicKey     string    `json:"public_key"`
+	Endpoint      string    `json:"endpoint"`
+	Resolved      string    `json:"resolved,omitempty"`
+	LastHandshake time.Time `json:"last_handshake"`
+	DNSSEC        string    `json:"dnssec,omitempty"`
+	Probe         string    `json:"probe,omitempty"`
+	ProbeRTT      float64   `json:"probe_rtt_ms,omitempty"`
+	ProbeError    string    `json:"probe_error,omitempty"`
+}
+
+// ReadStatus reads the status dumped by the running service
+func ReadStatus() (*Status, error) {
+	b, err := os.ReadFile(StatusPath)
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err := json.Unmarshal(b, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// writeStatus dumps status of running ifaces, and reports counts to systemd. caller should hold d.lock
+func (d *daemon) writeStatus() {
+	notify(sdnotify.Status(fmt.Sprintf("%d interfaces running, %d pending", len(d.runIfaces), len(d.pendingIfaces))))
+
+	status := Status{
+		PID:       os.Getpid(),
+		UpdatedAt: time.Now(),
+		Ifaces:    make(map[string]*IfaceStatus),
+		Update:    d.update,
+	}
+	for name, iface := range d.runIfaces {
+		peers, err := quick.PeerStatus(name)
+		if err != nil {
+			log.Debug().Err(err).Str("iface", name).Msg("failed to get device, skip status")
+			continue
+		}
+		ifaceStatus := &IfaceStatus{}
+		for key, peer := range peers {
+			endpoint := iface.unresolvedEndpoints[key]
+			peerStatus := &PeerStatus{
+				PublicKey:     key.String(),
+				Endpoint:      endpoint,
+				LastHandshake: peer.LastHandshakeTime,
+			}
+			if peer.Endpoint != nil {
+				peerStatus.Resolved = peer.Endpoint.String()
+			}
+			if p, ok := iface.probes[key]; ok {
+				peerStatus.Probe = p.String()
+				if result, ok := iface.probeResults[key]; ok && result.err != nil {
+					peerStatus.ProbeError = result.err.Error()
+				} else if ok {
+					peerStatus.ProbeRTT = float64(result.rtt.Microseconds()) / 1000
+				}
+			}
+			if endpoint != "" {
+				peerStatus.DNSSEC = string(dns.DNSSECStatus(endpointHost(endpoint)))
+			}
+			ifaceStatus.Peers = append(ifaceStatus.Peers, peerStatus)
+		}
+		slices.SortFunc(ifaceStatus.Peers, func(a, b *PeerStatus) int {
+			return strings.Compare(a.PublicKey, b.PublicKey)
+		})
+		status.Ifaces[name] = ifaceStatus
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		log.Err(err).Msg("marshal status failed")
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(StatusPath), "."+filepath.Base(StatusPath))
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		log.Err(err).Msgf("write status to %s failed", tmp)
+		return
+	}
+	if err := os.Rename(tmp, StatusPath); err != nil {
+		log.Err(err).Msgf("write status to %s failed", StatusPath)
+	}
+}
+
+func endpointHost(endpoint string) string {
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return endpoint
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const RpcdPluginPath = "/usr/libexec/rpcd/wg-quick-op"
+const RpcdACLPath = "/usr/share/rpcd/acl.d/wg-quick-op.json"
+
+//go:embed rpcd-wg-quick-op
+var RpcdPluginFile []byte
+
+//go:embed rpcd-acl.json
+var RpcdACLFile []byte
+
+var hasUbus = sync.OnceValue(func() bool {
+	_, err := exec.LookPath("ubus")
+	return err == nil
+})
+
+// PeerEvent is sent as ubus event wg-quick-op.peer when a peer goes up or down
+type PeerEvent struct {
+	Iface    string `json:"iface"`
+	Peer     string `json:"peer"`
+	State    string `json:"state"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// sendEvent sends ubus event wg-quick-op.<typ>, no-op if ubus is not available
+func sendEvent(typ string, data any) {
+	if !hasUbus() {
+		return
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Err(err).Msg("marshal ubus event failed")
+		return
+	}
+	output, exitCode, err := utils.RunCommand("ubus", "send", "wg-quick-op."+typ, string(b))
+	if err != nil || exitCode != 0 {
+		log.Error().Err(err).Int("exitCode", exitCode).Str("output", output).Msgf("failed to send ubus event wg-quick-op.%s", typ)
+	}
+}
+
+// addRpcdPlugin installs the rpcd exec plugin exposing ubus object wg-quick-op, if rpcd is present
+func addRpcdPlugin(params ServiceParams) {
+	if _, err := os.Stat(filepath.Dir(RpcdPluginPath)); err != nil {
+		log.Debug().Msg("rpcd not found, skip installing ubus plugin")
+		return
+	}
+	if err := params.writeRendered(RpcdPluginPath, RpcdPluginFile, 0755); err != nil {
+		log.Err(err).Msgf("write %s failed", RpcdPluginPath)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(RpcdACLPath), 0755); err != nil {
+		log.Err(err).Msgf("create %s failed", filepath.Dir(RpcdACLPath))
+		return
+	}
+	if err := os.WriteFile(RpcdACLPath, RpcdACLFile, 0644); err != nil {
+		log.Err(err).Msgf("write %s failed", RpcdACLPath)
+		return
+	}
+	reloadRpcd()
+	log.Info().Msg("add ubus object wg-quick-op via rpcd success")
+}
+
+func rmRpcdPlugin() {
+	var removed bool
+	for _, path := range []string{RpcdPluginPath, RpcdACLPath} {
+		if err := os.Remove(path); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Err(err).Msgf("failed to delete %s", path)
+			}
+			continue
+		}
+		removed = true
+		log.Info().Msgf("removed %s", path)
+	}
+	if removed {
+		reloadRpcd()
+	}
+}
+
+func reloadRpcd() {
+	output, exitCode, err := utils.RunCommand("/etc/init.d/rpcd", "reload")
+	if err != nil || exitCode != 0 {
+		log.Error().Err(err).Int("exitCode", exitCode).Str("output", output).Msg("Failed to run '/etc/init.d/rpcd reload'. Please run it manually.")
+	}
+}
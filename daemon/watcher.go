@@ -1,6 +1,8 @@
 package daemon
 
 import (
+	"context"
+
 	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 
@@ -13,14 +15,19 @@ type WireguardWatcher struct {
 	RemoveCallback func(name string)
 }
 
-func (w *WireguardWatcher) Watch() {
+// Watch watches /etc/wireguard until ctx is done
+func (w *WireguardWatcher) Watch(ctx context.Context) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Error().Msgf("failed to create watcher: %v", err)
+		return
 	}
+	defer watcher.Close()
 	watcher.Add("/etc/wireguard")
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
@@ -50,3 +57,40 @@ func (w *WireguardWatcher) Watch() {
 		}
 	}
 }
+
+// watchConfig calls changed when the config file at path is written or replaced, until ctx is done
+func watchConfig(ctx context.Context, path string, changed func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Msgf("failed to create config watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+	// the directory is watched, as editors replace the file
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		log.Err(err).Msgf("failed to watch %s", path)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				log.Info().Msgf("config file changed: %s", event.Name)
+				changed()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Err(err).Msgf("config watcher error")
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package bird renders BIRD BGP protocols for WireGuard peers
+package bird
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Session is a BGP session with a peer over the tunnel
+type Session struct {
+	Iface string
+	Peer  string
+	// Neighbor is the peer's address in the tunnel, link-local ones are bound to Iface
+	Neighbor net.IP
+	ASN      uint32
+}
+
+// Symbol keeps characters allowed in BIRD symbols, replacing others with _
+func Symbol(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Name returns the protocol name, wg_<iface>_<key prefix>, stable as peers come and go
+func (s *Session) Name() string {
+	return "wg_" + Symbol(s.Iface) + "_" + Symbol(s.Peer[:min(8, len(s.Peer))])
+}
+
+// Render renders sessions as BIRD protocols inheriting template, which should set local AS and channels.
+// It fails if names of sessions collide, e.g. of interfaces dn11-a and dn11.a, as BIRD would reject them
+func Render(sessions []Session, template string) ([]byte, error) {
+	sessions = slices.Clone(sessions)
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return strings.Compare(a.Iface+a.Peer, b.Iface+b.Peer)
+	})
+	names := make(map[string]*Session)
+	for i := range sessions {
+		s := &sessions[i]
+		if other, ok := names[s.Name()]; ok {
+			return nil, fmt.Errorf("protocol name %s of %s peer %s collides with %s peer %s, rename one of the interfaces",
+				s.Name(), s.Iface, s.Peer, other.Iface, other.Peer)
+		}
+		names[s.Name()] = s
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString("# generated by wg-quick-op from /etc/wireguard, do not edit\n")
+	for _, s := range sessions {
+		fmt.Fprintf(b, "\nprotocol bgp %s", s.Name())
+		if template != "" {
+			fmt.Fprintf(b, " from %s", template)
+		}
+		b.WriteString(" {\n")
+		fmt.Fprintf(b, "\tdescription \"%s %s\";\n", s.Iface, s.Peer)
+		fmt.Fprintf(b, "\tneighbor %s as %d;\n", s.Neighbor, s.ASN)
+		// BIRD requires the interface for link-local neighbors
+		if s.Neighbor.IsLinkLocalUnicast() {
+			fmt.Fprintf(b, "\tinterface \"%s\";\n", s.Iface)
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes(), nil
+}
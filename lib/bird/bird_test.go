@@ -0,0 +1,43 @@
+package bird
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	b, err := Render([]Session{
+		{Iface: "dn11.b", Peer: "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", Neighbor: net.ParseIP("172.16.2.1"), ASN: 4211110002},
+		{Iface: "dn11-a", Peer: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", Neighbor: net.ParseIP("fe80::1"), ASN: 4211110722},
+		{Iface: "dn11.b", Peer: "gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=", Neighbor: net.ParseIP("172.16.2.2"), ASN: 4211110003},
+	}, "dnpeers")
+	assert.NoError(t, err)
+	assert.Equal(t, `# generated by wg-quick-op from /etc/wireguard, do not edit
+
+protocol bgp wg_dn11_a_xTIBA5rb from dnpeers {
+	description "dn11-a xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=";
+	neighbor fe80::1 as 4211110722;
+	interface "dn11-a";
+}
+
+protocol bgp wg_dn11_b_TrMvSoP4 from dnpeers {
+	description "dn11.b TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=";
+	neighbor 172.16.2.1 as 4211110002;
+}
+
+protocol bgp wg_dn11_b_gN65BkIK from dnpeers {
+	description "dn11.b gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA=";
+	neighbor 172.16.2.2 as 4211110003;
+}
+`, string(b))
+}
+
+func TestRenderCollision(t *testing.T) {
+	_, err := Render([]Session{
+		{Iface: "dn11-a", Peer: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", Neighbor: net.ParseIP("fe80::1"), ASN: 4211110722},
+		{Iface: "dn11.a", Peer: "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", Neighbor: net.ParseIP("fe80::2"), ASN: 4211110722},
+	}, "")
+	assert.ErrorContains(t, err, "wg_dn11_a_xTIBA5rb")
+}
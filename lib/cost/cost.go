@@ -0,0 +1,162 @@
+// Package cost smooths RTT of peers into link costs, and renders them for BIRD or as JSON
+package cost
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/lib/bird"
+)
+
+// Cost is the smoothed RTT of a peer and the cost derived from it
+type Cost struct {
+	// Name is the BIRD constant, wg_cost_<iface>_<key prefix>, stable as peers come and go
+	Name  string  `json:"name"`
+	Iface string  `json:"iface"`
+	Peer  string  `json:"peer"`
+	RTT   float64 `json:"rtt_ms"`
+	// Cost is RTT in milliseconds rounded up, in 1-65535 to fit OSPF cost, or the fallback if not measured yet
+	Cost     int  `json:"cost"`
+	Measured bool `json:"measured"`
+}
+
+// Name returns the BIRD constant of the peer's cost
+func Name(iface, peer string) string {
+	return "wg_cost_" + bird.Symbol(iface) + "_" + bird.Symbol(peer[:min(8, len(peer))])
+}
+
+type key struct {
+	iface, peer string
+}
+
+// Tracker keeps RTT EWMA of configured peers, and the costs last exported
+type Tracker struct {
+	peers    map[key]bool
+	rtt      map[key]float64
+	exported map[key]int
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		peers:    make(map[key]bool),
+		rtt:      make(map[key]float64),
+		exported: make(map[key]int),
+	}
+}
+
+// SetPeers sets peers of each interface, which are all exported, measured or not.
+// RTT of peers no longer configured is dropped
+func (t *Tracker) SetPeers(peers map[string][]string) {
+	clear(t.peers)
+	for iface, keys := range peers {
+		for _, peer := range keys {
+			t.peers[key{iface, peer}] = true
+		}
+	}
+	for k := range t.rtt {
+		if !t.peers[k] {
+			delete(t.rtt, k)
+		}
+	}
+}
+
+// Observe adds a RTT sample of the peer, weighted by alpha in (0, 1]
+func (t *Tracker) Observe(iface, peer string, rtt time.Duration, alpha float64) {
+	ms := float64(rtt.Microseconds()) / 1000
+	k := key{iface, peer}
+	if last, ok := t.rtt[k]; ok {
+		ms = alpha*ms + (1-alpha)*last
+	}
+	t.rtt[k] = ms
+}
+
+func toCost(rtt float64) int {
+	return int(min(max(math.Ceil(rtt), 1), 65535))
+}
+
+// cost returns the cost of a peer, or fallback if not measured yet
+func (t *Tracker) cost(k key, fallback int) (int, bool) {
+	rtt, ok := t.rtt[k]
+	if !ok {
+		return fallback, false
+	}
+	return toCost(rtt), true
+}
+
+// Changed reports whether costs should be exported again, as peers are added or removed,
+// or any cost differs from the exported one by more than threshold, relatively
+func (t *Tracker) Changed(threshold float64, fallback int) bool {
+	if len(t.peers) != len(t.exported) {
+		return true
+	}
+	for k := range t.peers {
+		last, ok := t.exported[k]
+		if !ok {
+			return true
+		}
+		c, _ := t.cost(k, fallback)
+		if math.Abs(float64(c-last))/float64(last) > threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Export returns costs of all peers sorted by name, and records them as exported.
+// Peers not measured yet get fallback, so constants referenced in bird.conf are always defined.
+// It fails if names collide, e.g. of interfaces dn11-a and dn11.a, as BIRD would reject the constants
+func (t *Tracker) Export(fallback int) ([]Cost, error) {
+	var costs []Cost
+	for k := range t.peers {
+		c := Cost{Name: Name(k.iface, k.peer), Iface: k.iface, Peer: k.peer}
+		c.Cost, c.Measured = t.cost(k, fallback)
+		if c.Measured {
+			c.RTT = math.Round(t.rtt[k]*1000) / 1000
+		}
+		costs = append(costs, c)
+	}
+	slices.SortFunc(costs, func(a, b Cost) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Iface, b.Iface))
+	})
+	for i := 1; i < len(costs); i++ {
+		if a, b := costs[i-1], costs[i]; a.Name == b.Name {
+			return nil, fmt.Errorf("cost name %s of %s peer %s collides with %s peer %s, rename one of the interfaces",
+				b.Name, b.Iface, b.Peer, a.Iface, a.Peer)
+		}
+	}
+
+	clear(t.exported)
+	for _, c := range costs {
+		t.exported[key{c.Iface, c.Peer}] = c.Cost
+	}
+	return costs, nil
+}
+
+// Bird renders costs as BIRD constants, to be included in bird.conf and used like
+// `cost wg_cost_dn11_xTIBA5rb;` in OSPF interfaces or `bgp_local_pref = 1000 - wg_cost_dn11_xTIBA5rb;` in BGP import filters
+func Bird(costs []Cost) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString("# generated by wg-quick-op, do not edit\n")
+	for _, c := range costs {
+		if c.Measured {
+			fmt.Fprintf(b, "define %s = %d; # %s %s rtt %.3fms\n", c.Name, c.Cost, c.Iface, c.Peer, c.RTT)
+		} else {
+			fmt.Fprintf(b, "define %s = %d; # %s %s not measured\n", c.Name, c.Cost, c.Iface, c.Peer)
+		}
+	}
+	return b.Bytes()
+}
+
+// JSON renders costs as a JSON array
+func JSON(costs []Cost) ([]byte, error) {
+	if costs == nil {
+		costs = []Cost{}
+	}
+	return json.MarshalIndent(costs, "", "  ")
+}
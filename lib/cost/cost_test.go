@@ -0,0 +1,70 @@
+package cost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	const (
+		a  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+		b1 = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+		b2 = "gN65BkIKy1eCE9pP1wdc8ROUtkHLF2PfAqYdyYBz6EA="
+	)
+	tr := NewTracker()
+	assert.False(t, tr.Changed(0.2, 65535))
+
+	tr.SetPeers(map[string][]string{"dn11-a": {a}, "dn11.b": {b1, b2}})
+	tr.Observe("dn11-a", a, 10*time.Millisecond, 0.5)
+	tr.Observe("dn11.b", b1, 300*time.Microsecond, 0.5)
+	assert.True(t, tr.Changed(0.2, 65535))
+
+	// b2 is not measured yet, but still defined with the fallback
+	costs, err := tr.Export(65535)
+	assert.NoError(t, err)
+	assert.Equal(t, []Cost{
+		{"wg_cost_dn11_a_xTIBA5rb", "dn11-a", a, 10, 10, true},
+		{"wg_cost_dn11_b_TrMvSoP4", "dn11.b", b1, 0.3, 1, true},
+		{"wg_cost_dn11_b_gN65BkIK", "dn11.b", b2, 0, 65535, false},
+	}, costs)
+	assert.False(t, tr.Changed(0.2, 65535))
+
+	tr.Observe("dn11.b", b2, 50*time.Millisecond, 0.5)
+	assert.True(t, tr.Changed(0.2, 65535))
+	tr.Export(65535)
+
+	// smoothed 10 -> 11, within threshold
+	tr.Observe("dn11-a", a, 12*time.Millisecond, 0.5)
+	assert.False(t, tr.Changed(0.2, 65535))
+	// 11 -> 20.5
+	tr.Observe("dn11-a", a, 30*time.Millisecond, 0.5)
+	assert.True(t, tr.Changed(0.2, 65535))
+	tr.Export(65535)
+
+	// names don't change as peers come and go
+	tr.SetPeers(map[string][]string{"dn11-a": {a}})
+	assert.True(t, tr.Changed(0.2, 65535))
+	exported, err := tr.Export(65535)
+	assert.NoError(t, err)
+	assert.Len(t, exported, 1)
+	assert.Equal(t, "wg_cost_dn11_a_xTIBA5rb", exported[0].Name)
+
+	bird := string(Bird(costs))
+	assert.Contains(t, bird, "define wg_cost_dn11_a_xTIBA5rb = 10;")
+	assert.Contains(t, bird, "define wg_cost_dn11_b_gN65BkIK = 65535; # dn11.b "+b2+" not measured")
+	b, err := JSON(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(b))
+}
+
+func TestExportCollision(t *testing.T) {
+	const a = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	tr := NewTracker()
+	tr.SetPeers(map[string][]string{"dn11-a": {a}, "dn11.a": {a}})
+	_, err := tr.Export(65535)
+	assert.ErrorContains(t, err, "wg_cost_dn11_a_xTIBA5rb")
+	// nothing is recorded as exported
+	assert.True(t, tr.Changed(0.2, 65535))
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/dn-11/wg-quick-op/conf"
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -21,14 +22,28 @@ var (
 	defaultDNSClient = &dns.Client{
 		Timeout: 500 * time.Millisecond,
 	}
+	tcpDNSClient = &dns.Client{
+		Net:     "tcp",
+		Timeout: 500 * time.Millisecond,
+	}
 	ResolveUDPAddr = net.ResolveUDPAddr
 )
 
 const MaxCnameDepth = 5
 
+// nsPort is the port to query authoritative NS servers
+var nsPort uint16 = 53
+
 func Init() {
-	if !conf.EnhancedDNS.DirectResolver.Enabled {
-		return
+	if conf.EnhancedDNS.Timeout > 0 {
+		defaultDNSClient.Timeout = conf.EnhancedDNS.Timeout
+		tcpDNSClient.Timeout = conf.EnhancedDNS.Timeout
+	}
+	if conf.EnhancedDNS.Retry > 0 {
+		queryRetry = conf.EnhancedDNS.Retry
+	}
+	if conf.EnhancedDNS.RateLimit > 0 {
+		globalRateLimiter.SetLimit(rate.Limit(conf.EnhancedDNS.RateLimit))
 	}
 
 	// 1. load from config
@@ -73,10 +88,24 @@ func Init() {
 		}
 	}
 
+	if !conf.EnhancedDNS.DirectResolver.Enabled {
+		if conf.EnhancedDNS.DNSSEC.Enabled {
+			log.Error().Msg("enhanced_dns.dnssec requires enhanced_dns.direct_resolver, DNSSEC validation is disabled")
+		}
+		return
+	}
+	initDNSSEC()
+
 	ResolveUDPAddr = ResolveUDPAddrDirect
+	LookupSRV = lookupSRVDirect
 }
 
 func ResolveUDPAddrDirect(_ string, addr string) (*net.UDPAddr, error) {
+	return ResolveUDPAddrDirectContext(context.Background(), addr)
+}
+
+// ResolveUDPAddrDirectContext is like ResolveUDPAddrDirect, ctx could carry a Trace by WithTrace
+func ResolveUDPAddrDirectContext(ctx context.Context, addr string) (*net.UDPAddr, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, fmt.Errorf("split host port failed: %w", err)
@@ -87,14 +116,14 @@ func ResolveUDPAddrDirect(_ string, addr string) (*net.UDPAddr, error) {
 		return nil, fmt.Errorf("parse port failed: %w", err)
 	}
 
-	ip, err := resolveHostDirect(host)
+	ip, err := resolveHostDirect(ctx, host)
 	if err != nil {
 		return nil, fmt.Errorf("resolve host direct failed: %w", err)
 	}
 	return &net.UDPAddr{IP: net.IP(ip.AsSlice()).To16(), Port: numPort}, nil
 }
 
-func resolveHostDirect(addr string) (netip.Addr, error) {
+func resolveHostDirect(ctx context.Context, addr string) (netip.Addr, error) {
 	// check if ip
 	parsedAddr, err := netip.ParseAddr(addr)
 	if err == nil {
@@ -102,52 +131,99 @@ func resolveHostDirect(addr string) (netip.Addr, error) {
 	}
 
 	// queryWithRetry dns in direct mode
-	ip, err := directDNS(addr)
+	addrs, err := directLookup(ctx, addr)
 	if err != nil {
 		return netip.Addr{}, err
 	}
-	return ip, nil
+	return addrs[0], nil
 }
 
 func directDNS(domain string) (netip.Addr, error) {
-	domain, err := unfoldCNAME(dns.Fqdn(domain), MaxCnameDepth)
+	addrs, err := directLookup(context.Background(), domain)
 	if err != nil {
 		return netip.Addr{}, err
 	}
+	return addrs[0], nil
+}
 
-	for ns := range nsAddrIter(domain) {
-		for addr := range queryAAndAAAAAddrIter(domain, []netip.AddrPort{netip.AddrPortFrom(ns, 53)}) {
-			return addr, nil
+// directLookup resolves domain from its authoritative NS servers, and falls back to the upstream
+// servers when the authoritative lookup fails
+func directLookup(ctx context.Context, domain string) ([]netip.Addr, error) {
+	addrs, status, err := directLookupValidated(ctx, domain)
+	if dnssecEnabled {
+		setDNSSECStatus(domain, status)
+		traceFrom(ctx).setDNSSEC(status)
+		logger := log.With().Str("domain", domain).Str("dnssec", string(status)).Logger()
+		switch status {
+		case StatusBogus:
+			logger.Error().Err(err).Msg("DNSSEC validation failed, answer rejected")
+		case StatusInsecure:
+			logger.Info().Msg("DNSSEC validation skipped, domain is insecure")
+		case StatusSecure:
+			logger.Debug().Msg("DNSSEC validation passed")
+		}
+	}
+	return addrs, err
+}
+
+func directLookupValidated(ctx context.Context, domain string) ([]netip.Addr, ValidationStatus, error) {
+	domain, status, err := unfoldCNAME(ctx, dns.Fqdn(domain), MaxCnameDepth)
+	if err != nil {
+		return nil, status, err
+	}
+
+	servers, err := nsServers(ctx, domain)
+	if err == nil {
+		addrs, s, err := lookupAddrs(ctx, domain, servers)
+		if err == nil {
+			return addrs, status.combine(s), nil
 		}
+		// a bogus answer is an attack or a broken zone, which upstream servers should not paper over
+		if s == StatusBogus {
+			return nil, StatusBogus, fmt.Errorf("answer from NS server is bogus: %w", err)
+		}
+		log.Warn().Err(err).Str("domain", domain).Msg("query NS server failed, fallback to upstream")
+	} else {
+		log.Warn().Err(err).Str("domain", domain).Msg("find NS server failed, fallback to upstream")
 	}
-	return netip.Addr{}, errors.New("no address found")
+
+	addrs, s, err := lookupAddrs(ctx, domain, publicDNS)
+	return addrs, status.combine(s), err
 }
 
-func unfoldCNAME(domain string, depth int) (string, error) {
+func unfoldCNAME(ctx context.Context, domain string, depth int) (string, ValidationStatus, error) {
 	if depth == 0 {
-		return "", errors.New("CNAME is too deep")
+		return "", StatusUnknown, errors.New("CNAME is too deep")
 	}
-	rec, err := queryWithRetryWithList(context.Background(), domain, dns.TypeA, publicDNS)
+	rec, err := queryWithRetryWithList(ctx, domain, dns.TypeA, publicDNS)
 	if err != nil {
-		return "", err
+		return "", StatusUnknown, err
+	}
+	if rec.Rcode == dns.RcodeNameError {
+		return "", StatusUnknown, fmt.Errorf("domain %s not exist", domain)
 	}
 	for _, ans := range rec.Answer {
-		if ans.Header().Rrtype == dns.TypeCNAME {
-			return unfoldCNAME(ans.(*dns.CNAME).Target, depth-1)
+		if ans.Header().Rrtype != dns.TypeCNAME || !strings.EqualFold(ans.Header().Name, domain) {
+			continue
+		}
+		status, err := validateAnswer(ctx, domain, []uint16{dns.TypeCNAME}, rec)
+		if err != nil {
+			return "", status, err
 		}
+		traceFrom(ctx).addCNAME(ans.(*dns.CNAME).Target)
+		target, s, err := unfoldCNAME(ctx, ans.(*dns.CNAME).Target, depth-1)
+		return target, status.combine(s), err
 	}
-	return domain, nil
+	return domain, StatusUnknown, nil
 }
 
-func nsAddrIter(domain string) func(yield func(addr netip.Addr) bool) {
-	// find NS
-	var nsRec *dns.Msg
-
+// findNS walks up from domain to find the zone it belongs to, and returns the NS response of the zone
+func findNS(ctx context.Context, domain string) (*dns.Msg, error) {
 DomainTrim:
-	for domain != "" {
-		rec, err := queryWithRetryWithList(context.Background(), domain, dns.TypeNS, publicDNS)
+	for domain != "" && domain != "." {
+		rec, err := queryWithRetryWithList(ctx, domain, dns.TypeNS, publicDNS)
 		if err != nil {
-			return nil
+			return nil, fmt.Errorf("query NS of %s failed: %w", domain, err)
 		}
 
 		// check SOA
@@ -161,69 +237,54 @@ DomainTrim:
 
 		for _, rr := range rec.Answer {
 			if rr.Header().Rrtype == dns.TypeNS {
-				nsRec = rec
-				break DomainTrim
+				return rec, nil
 			}
 		}
 		_, after, found := strings.Cut(domain, ".")
 		if !found {
-			return nil
+			break
 		}
 		domain = after
 	}
+	return nil, errors.New("cannot find NS server")
+}
 
-	if domain == "" {
-		log.Error().Msg("cannot find NS server")
+// nsServers returns addresses of all NS servers of the zone domain belongs to, both IPv4 and IPv6
+func nsServers(ctx context.Context, domain string) ([]netip.AddrPort, error) {
+	nsRec, err := findNS(ctx, domain)
+	if err != nil {
+		return nil, err
 	}
 
 	rand.Shuffle(len(nsRec.Answer), func(i, j int) {
 		nsRec.Answer[i], nsRec.Answer[j] = nsRec.Answer[j], nsRec.Answer[i]
 	})
 
-	return func(yield func(addr netip.Addr) bool) {
-		for _, rr := range nsRec.Answer {
-			ns, ok := rr.(*dns.NS)
-			if !ok {
-				log.Warn().Str("name", rr.Header().Name).Msgf("%s is not a NS Record", rr.Header().Name)
-				continue
-			}
-			// check additional
-			var hasRelative bool
-			for _, rr := range nsRec.Extra {
-				if rr.Header().Name != ns.Ns {
-					continue
-				}
-				switch rr := rr.(type) {
-				case *dns.A:
-					addr, ok := netip.AddrFromSlice(rr.A)
-					if !ok {
-						log.Warn().Str("rr", rr.String()).Msgf("convert dns response to netip")
-					}
-					hasRelative = true
-					if !yield(addr) {
-						return
-					}
-				case *dns.AAAA:
-					addr, ok := netip.AddrFromSlice(rr.AAAA)
-					if !ok {
-						log.Warn().Str("rr", rr.String()).Msgf("convert dns response to netip")
-					}
-					hasRelative = true
-					if !yield(addr) {
-						return
-					}
-				}
-			}
+	var servers []netip.AddrPort
+	for _, rr := range nsRec.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			log.Warn().Str("name", rr.Header().Name).Msgf("%s is not a NS Record", rr.Header().Name)
+			continue
+		}
 
-			if hasRelative {
+		// check additional
+		glue := addrsFromRR(nsRec.Extra, ns.Ns)
+		if len(glue) == 0 {
+			glue, err = queryAddrs(ctx, ns.Ns, publicDNS)
+			if err != nil {
+				log.Warn().Err(err).Str("ns", ns.Ns).Msg("resolve NS server failed")
 				continue
 			}
-
-			for addr := range queryAAndAAAAAddrIter(ns.Ns, publicDNS) {
-				if !yield(addr) {
-					return
-				}
-			}
 		}
+		for _, addr := range glue {
+			servers = append(servers, netip.AddrPortFrom(addr, nsPort))
+		}
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no address found for NS servers of %s", nsRec.Question[0].Name)
 	}
+	traceFrom(ctx).setNS(servers)
+	return servers, nil
 }
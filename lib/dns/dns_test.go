@@ -1,32 +1,140 @@
 package dns
 
 import (
+	"net"
 	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestDirectDNS(t *testing.T) {
-	testcases := []string{
-		"www.baidu.com",
-		"www.hdu.edu.cn",
-	}
-	publicDNS = []netip.AddrPort{netip.MustParseAddrPort("223.5.5.5:53"), netip.MustParseAddrPort("119.29.29.29:53")}
-	defaultDNSClient = &dns.Client{
-		Timeout: 500 * time.Millisecond,
-	}
+// startServer runs an in-process dns server on 127.0.0.1
+func startServer(t *testing.T, handler dns.HandlerFunc) netip.AddrPort {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return netip.MustParseAddrPort(pc.LocalAddr().String())
+}
 
-	for _, testcase := range testcases {
-		t.Logf("Attempting to queryWithRetry %s", testcase)
-		ip, err := directDNS(testcase)
-		if err != nil {
-			t.Errorf("directDNS error:%v", err)
+// zoneHandler answers with records keyed by "name type", e.g. "www.example.test. A",
+// each line is prefixed by the section (an/ns/ex) it goes to. Unknown questions get rcode
+func zoneHandler(rcode int, records map[string]string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		key := q.Name + " " + dns.TypeToString[q.Qtype]
+		rrs, ok := records[key]
+		if !ok && rcode != dns.RcodeSuccess {
+			m.Rcode = rcode
+			_ = w.WriteMsg(m)
 			return
 		}
-		t.Logf("Resolved IP is %s", ip)
+		for _, line := range strings.Split(rrs, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			section, text, _ := strings.Cut(line, " ")
+			rr, err := dns.NewRR(text)
+			if err != nil {
+				panic(err)
+			}
+			switch section {
+			case "an":
+				m.Answer = append(m.Answer, rr)
+			case "ns":
+				m.Ns = append(m.Ns, rr)
+			case "ex":
+				m.Extra = append(m.Extra, rr)
+			}
+		}
+		_ = w.WriteMsg(m)
+	}
+}
+
+func setupResolver(t *testing.T, upstream, authoritative netip.AddrPort) {
+	t.Helper()
+	oldPublic, oldPort, oldClient := publicDNS, nsPort, defaultDNSClient
+	publicDNS = []netip.AddrPort{upstream}
+	nsPort = authoritative.Port()
+	defaultDNSClient = &dns.Client{Timeout: 500 * time.Millisecond}
+	dnskeyCache.Clear()
+	t.Cleanup(func() {
+		publicDNS, nsPort, defaultDNSClient = oldPublic, oldPort, oldClient
+		dnskeyCache.Clear()
+	})
+}
+
+var upstreamRecords = map[string]string{
+	"www.example.test. A":       "an www.example.test. 60 IN A 198.51.100.1",
+	"www.example.test. AAAA":    "ns example.test. 60 IN SOA ns1.example.test. admin.example.test. 1 3600 600 86400 60",
+	"alias.example.test. A":     "an alias.example.test. 60 IN CNAME www.example.test.\nan www.example.test. 60 IN A 198.51.100.1",
+	"www.example.test. NS":      "ns example.test. 60 IN SOA ns1.example.test. admin.example.test. 1 3600 600 86400 60",
+	"example.test. NS":          "an example.test. 60 IN NS ns1.example.test.\nan example.test. 60 IN NS ns2.example.test.\nex ns1.example.test. 60 IN A 127.0.0.1\nex ns2.example.test. 60 IN A 127.0.0.2",
+	"ns1.example.test. A":       "an ns1.example.test. 60 IN A 127.0.0.1",
+	"noauth.example.test. A":    "an noauth.example.test. 60 IN A 198.51.100.2",
+	"noauth.example.test. NS":   "",
+	"noauth.example.test. AAAA": "",
+}
+
+var authoritativeRecords = map[string]string{
+	"www.example.test. A":    "an www.example.test. 60 IN A 192.0.2.1",
+	"www.example.test. AAAA": "an www.example.test. 60 IN AAAA 2001:db8::1",
+}
+
+func TestDirectDNS(t *testing.T) {
+	upstream := startServer(t, zoneHandler(dns.RcodeRefused, upstreamRecords))
+	authoritative := startServer(t, zoneHandler(dns.RcodeRefused, authoritativeRecords))
+	setupResolver(t, upstream, authoritative)
+
+	testcases := map[string]string{
+		"www.example.test":   "192.0.2.1",
+		"alias.example.test": "192.0.2.1",
+		// no NS could be found, fallback to upstream
+		"noauth.example.test": "198.51.100.2",
 	}
+	for domain, want := range testcases {
+		t.Run(domain, func(t *testing.T) {
+			ip, err := directDNS(domain)
+			require.NoError(t, err)
+			assert.Equal(t, want, ip.String())
+		})
+	}
+
+	addrs, err := directLookup(t.Context(), "www.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}, addrs)
+}
+
+func TestDirectDNSFailure(t *testing.T) {
+	upstream := startServer(t, zoneHandler(dns.RcodeServerFailure, nil))
+	setupResolver(t, upstream, upstream)
+
+	_, err := directDNS("www.example.test")
+	assert.Error(t, err)
+
+	_, err = nsServers(t.Context(), "www.example.test.")
+	assert.Error(t, err)
+}
+
+func TestResolveUDPAddrDirect(t *testing.T) {
+	upstream := startServer(t, zoneHandler(dns.RcodeRefused, upstreamRecords))
+	authoritative := startServer(t, zoneHandler(dns.RcodeRefused, authoritativeRecords))
+	setupResolver(t, upstream, authoritative)
+
+	addr, err := ResolveUDPAddrDirect("", "www.example.test:12345")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:12345", addr.String())
 }
 
 func TestResolveUDP(t *testing.T) {
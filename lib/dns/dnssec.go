@@ -0,0 +1,387 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// ValidationStatus is the DNSSEC validation result of a name
+type ValidationStatus string
+
+const (
+	// StatusUnknown means the name is not validated, DNSSEC is disabled or the system resolver is used
+	StatusUnknown ValidationStatus = ""
+	// StatusSecure means the whole chain of trust is verified
+	StatusSecure ValidationStatus = "secure"
+	// StatusInsecure means the name is proven to be in an unsigned zone, or not under any trust anchor
+	StatusInsecure ValidationStatus = "insecure"
+	// StatusBogus means the answer failed validation and is rejected
+	StatusBogus ValidationStatus = "bogus"
+)
+
+// DefaultTrustAnchors are DS records of the root KSKs
+var DefaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+var (
+	dnssecEnabled    bool
+	trustAnchors     []*dns.DS
+	validationStatus sync.Map
+	// dnskeyCache maps zones to their verified *cachedDNSKEY
+	dnskeyCache sync.Map
+)
+
+// cachedDNSKEY is a verified DNSKEY RRset, kept until its TTL expires
+type cachedDNSKEY struct {
+	keys   []*dns.DNSKEY
+	expire time.Time
+}
+
+// combine returns the weaker one of two status
+func (s ValidationStatus) combine(o ValidationStatus) ValidationStatus {
+	rank := func(s ValidationStatus) int {
+		return slices.Index([]ValidationStatus{StatusUnknown, StatusSecure, StatusInsecure, StatusBogus}, s)
+	}
+	if rank(o) > rank(s) {
+		return o
+	}
+	return s
+}
+
+// DNSSECStatus returns the last validation status of host resolved by the direct resolver
+func DNSSECStatus(host string) ValidationStatus {
+	v, ok := validationStatus.Load(dns.CanonicalName(host))
+	if !ok {
+		return StatusUnknown
+	}
+	return v.(ValidationStatus)
+}
+
+func setDNSSECStatus(host string, status ValidationStatus) {
+	if !dnssecEnabled {
+		return
+	}
+	validationStatus.Store(dns.CanonicalName(host), status)
+}
+
+func initDNSSEC() {
+	dnssecEnabled = conf.EnhancedDNS.DNSSEC.Enabled
+	if !dnssecEnabled {
+		return
+	}
+
+	anchors := conf.EnhancedDNS.DNSSEC.TrustAnchor
+	if len(anchors) == 0 {
+		anchors = DefaultTrustAnchors
+	}
+	for _, str := range anchors {
+		rr, err := dns.NewRR(str)
+		if err != nil {
+			log.Error().Err(err).Str("anchor", str).Msg("cannot parse DNSSEC trust anchor")
+			continue
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			log.Error().Str("anchor", str).Msg("DNSSEC trust anchor is not a DS record")
+			continue
+		}
+		ds.Hdr.Name = dns.CanonicalName(ds.Hdr.Name)
+		trustAnchors = append(trustAnchors, ds)
+	}
+	if len(trustAnchors) == 0 {
+		log.Warn().Msg("no valid DNSSEC trust anchor, all answers will be treated as insecure")
+	}
+}
+
+// validateAnswer validates rrsets of qTypes owned by name in msgs
+func validateAnswer(ctx context.Context, name string, qTypes []uint16, msgs ...*dns.Msg) (ValidationStatus, error) {
+	if !dnssecEnabled {
+		return StatusUnknown, nil
+	}
+
+	status := StatusUnknown
+	for _, msg := range msgs {
+		for _, qType := range qTypes {
+			rrset, sigs := rrsetOf(msg.Answer, name, qType)
+			if len(rrset) == 0 {
+				continue
+			}
+			s, err := validateRRset(ctx, name, rrset, sigs)
+			if err != nil {
+				return StatusBogus, err
+			}
+			status = status.combine(s)
+		}
+	}
+	return status, nil
+}
+
+// validateRRset validates rrset owned by name with the chain of trust from the trust anchor
+func validateRRset(ctx context.Context, name string, rrset []dns.RR, sigs []*dns.RRSIG) (ValidationStatus, error) {
+	zone, keys, status, err := chainOfTrust(ctx, dns.CanonicalName(name))
+	if err != nil {
+		return StatusBogus, err
+	}
+	if status == StatusInsecure {
+		return StatusInsecure, nil
+	}
+	if err := verifyRRset(rrset, sigs, keys, zone); err != nil {
+		return StatusBogus, fmt.Errorf("verify %s %s failed: %w", name, dns.TypeToString[rrset[0].Header().Rrtype], err)
+	}
+	return StatusSecure, nil
+}
+
+// chainOfTrust walks from the closest trust anchor down to name, returns the zone name belongs to
+// and its verified keys, or StatusInsecure if an unsigned delegation is proven on the way
+func chainOfTrust(ctx context.Context, name string) (string, []*dns.DNSKEY, ValidationStatus, error) {
+	var (
+		zone   string
+		found  bool
+		anchor []*dns.DS
+	)
+	for _, ds := range trustAnchors {
+		if dns.IsSubDomain(ds.Hdr.Name, name) && (!found || dns.CountLabel(ds.Hdr.Name) > dns.CountLabel(zone)) {
+			zone, found = ds.Hdr.Name, true
+		}
+	}
+	if !found {
+		return "", nil, StatusInsecure, nil
+	}
+	for _, ds := range trustAnchors {
+		if ds.Hdr.Name == zone {
+			anchor = append(anchor, ds)
+		}
+	}
+
+	keys, err := verifiedDNSKEY(ctx, zone, anchor)
+	if err != nil {
+		return "", nil, StatusBogus, err
+	}
+
+	labels := dns.SplitDomainName(name)
+	for i := len(labels) - dns.CountLabel(zone) - 1; i >= 0; i-- {
+		child := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		rec, err := queryWithRetryWithList(ctx, child, dns.TypeDS, publicDNS)
+		if err != nil {
+			return "", nil, StatusBogus, fmt.Errorf("query DS of %s failed: %w", child, err)
+		}
+		dsSet, sigs := rrsetOf(rec.Answer, child, dns.TypeDS)
+		if len(dsSet) > 0 {
+			if err := verifyRRset(dsSet, sigs, keys, zone); err != nil {
+				return "", nil, StatusBogus, fmt.Errorf("verify DS of %s failed: %w", child, err)
+			}
+			var ds []*dns.DS
+			for _, rr := range dsSet {
+				ds = append(ds, rr.(*dns.DS))
+			}
+			if keys, err = verifiedDNSKEY(ctx, child, ds); err != nil {
+				return "", nil, StatusBogus, err
+			}
+			zone = child
+			continue
+		}
+
+		cut, err := isZoneCut(ctx, child)
+		if err != nil {
+			return "", nil, StatusBogus, err
+		}
+		if !cut {
+			continue
+		}
+		// an unsigned delegation must be proven by a signed NSEC/NSEC3 without DS
+		if err := verifyNoDS(rec, child, keys, zone); err != nil {
+			return "", nil, StatusBogus, fmt.Errorf("missing DS of %s is not proven: %w", child, err)
+		}
+		return child, nil, StatusInsecure, nil
+	}
+	return zone, keys, StatusSecure, nil
+}
+
+func isZoneCut(ctx context.Context, name string) (bool, error) {
+	rec, err := queryWithRetryWithList(ctx, name, dns.TypeSOA, publicDNS)
+	if err != nil {
+		return false, fmt.Errorf("query SOA of %s failed: %w", name, err)
+	}
+	soa, _ := rrsetOf(rec.Answer, name, dns.TypeSOA)
+	return len(soa) > 0, nil
+}
+
+// verifiedDNSKEY fetches DNSKEY of zone, and verifies it with the DS records from the parent zone.
+// Verified keys are cached until the TTL of the RRset or its signatures expires
+func verifiedDNSKEY(ctx context.Context, zone string, dsSet []*dns.DS) ([]*dns.DNSKEY, error) {
+	if v, ok := dnskeyCache.Load(zone); ok && time.Now().Before(v.(*cachedDNSKEY).expire) {
+		return v.(*cachedDNSKEY).keys, nil
+	}
+
+	rec, err := queryWithRetryWithList(ctx, zone, dns.TypeDNSKEY, publicDNS)
+	if err != nil {
+		return nil, fmt.Errorf("query DNSKEY of %s failed: %w", zone, err)
+	}
+	rrset, sigs := rrsetOf(rec.Answer, zone, dns.TypeDNSKEY)
+
+	var keys, trusted []*dns.DNSKEY
+	for _, rr := range rrset {
+		key := rr.(*dns.DNSKEY)
+		keys = append(keys, key)
+		for _, ds := range dsSet {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			if d := key.ToDS(ds.DigestType); d != nil && strings.EqualFold(d.Digest, ds.Digest) {
+				trusted = append(trusted, key)
+				break
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("no DNSKEY of %s matches DS", zone)
+	}
+	if err := verifyRRset(rrset, sigs, trusted, zone); err != nil {
+		return nil, fmt.Errorf("verify DNSKEY of %s failed: %w", zone, err)
+	}
+
+	ttl := rrset[0].Header().Ttl
+	for _, rr := range rrset {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	for _, sig := range sigs {
+		ttl = min(ttl, sig.Hdr.Ttl, sig.OrigTtl)
+	}
+	dnskeyCache.Store(zone, &cachedDNSKEY{keys: keys, expire: time.Now().Add(time.Duration(ttl) * time.Second)})
+	return keys, nil
+}
+
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, signer string) error {
+	if len(sigs) == 0 {
+		return errors.New("no RRSIG found")
+	}
+	now := time.Now()
+	for _, sig := range sigs {
+		if !strings.EqualFold(sig.SignerName, signer) || !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if err := sig.Verify(key, rrset); err == nil {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no valid RRSIG signed by %s", signer)
+}
+
+// verifyNoDS checks the authority section of a DS response proves there is no DS for name, by a signed
+// NSEC/NSEC3 matching name, or by the closest encloser proof with an opt-out NSEC3 covering the next
+// closer name (RFC 5155 section 8.6)
+func verifyNoDS(rec *dns.Msg, name string, keys []*dns.DNSKEY, signer string) error {
+	var (
+		nsec3s    []*dns.NSEC3
+		verifyErr error
+	)
+	verify := func(rr dns.RR) bool {
+		rrset, sigs := rrsetOf(rec.Ns, rr.Header().Name, rr.Header().Rrtype)
+		if err := verifyRRset(rrset, sigs, keys, signer); err != nil {
+			verifyErr = err
+			return false
+		}
+		return true
+	}
+	for _, rr := range rec.Ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			if strings.EqualFold(rr.Hdr.Name, name) && !slices.Contains(rr.TypeBitMap, dns.TypeDS) && verify(rr) {
+				return nil
+			}
+		case *dns.NSEC3:
+			if verify(rr) {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	if len(nsec3s) == 0 {
+		if verifyErr != nil {
+			return verifyErr
+		}
+		return errors.New("no NSEC/NSEC3 record proves it")
+	}
+
+	matched := func(name string) *dns.NSEC3 {
+		for _, rr := range nsec3s {
+			if rr.Match(name) {
+				return rr
+			}
+		}
+		return nil
+	}
+	if rr := matched(name); rr != nil {
+		// the delegation point, which belongs to the parent zone
+		if slices.Contains(rr.TypeBitMap, dns.TypeDS) || slices.Contains(rr.TypeBitMap, dns.TypeSOA) {
+			return fmt.Errorf("NSEC3 matching %s has DS or SOA", name)
+		}
+		return nil
+	}
+
+	// closest encloser proof: the closest existing ancestor is matched, and the name one label
+	// longer, the next closer name, is covered by an opt-out span
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		if !dns.IsSubDomain(signer, encloser) {
+			break
+		}
+		rr := matched(encloser)
+		if rr == nil {
+			continue
+		}
+		// names under a delegation or DNAME are not in this zone
+		if slices.Contains(rr.TypeBitMap, dns.TypeDNAME) ||
+			slices.Contains(rr.TypeBitMap, dns.TypeNS) && !slices.Contains(rr.TypeBitMap, dns.TypeSOA) {
+			return fmt.Errorf("closest encloser %s is a delegation or DNAME", encloser)
+		}
+		nextCloser := dns.Fqdn(strings.Join(labels[i-1:], "."))
+		if slices.ContainsFunc(nsec3s, func(rr *dns.NSEC3) bool {
+			return rr.Flags&1 == 1 && rr.Cover(nextCloser)
+		}) {
+			return nil
+		}
+		return fmt.Errorf("next closer name %s is not covered by an opt-out NSEC3", nextCloser)
+	}
+	return fmt.Errorf("no closest encloser of %s is proven", name)
+}
+
+// rrsetOf collects records of qType owned by name, and the RRSIG covering them
+func rrsetOf(rrs []dns.RR, name string, qType uint16) ([]dns.RR, []*dns.RRSIG) {
+	var (
+		rrset []dns.RR
+		sigs  []*dns.RRSIG
+	)
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qType {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+		if rr.Header().Rrtype == qType {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset, sigs
+}
@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"crypto"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZone signs records of example.test. with a generated KSK
+type testZone struct {
+	t    *testing.T
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T) *testZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.test.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 60},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	require.NoError(t, err)
+	return &testZone{t: t, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z *testZone) sign(rrset ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: "example.test.",
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	require.NoError(z.t, sig.Sign(z.priv, rrset))
+	return append(rrset, sig)
+}
+
+func testA(name, ip string) *dns.A {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP(ip)}
+}
+
+func signedZone(t *testing.T) (*dns.DS, map[string][]dns.RR) {
+	t.Helper()
+	z := newTestZone(t)
+	forged := z.sign(testA("bad.example.test.", "192.0.2.2"))
+	forged[0] = testA("bad.example.test.", "203.0.113.66")
+
+	return z.key.ToDS(dns.SHA256), map[string][]dns.RR{
+		"example.test. DNSKEY":  z.sign(z.key),
+		"www.example.test. A":   z.sign(testA("www.example.test.", "192.0.2.1")),
+		"bad.example.test. A":   forged,
+		"nosig.example.test. A": {testA("nosig.example.test.", "192.0.2.3")},
+	}
+}
+
+func TestDNSSEC(t *testing.T) {
+	ds, records := signedZone(t)
+	var dnskeyQueries atomic.Int32
+	upstream := startServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		if q.Qtype == dns.TypeDNSKEY {
+			dnskeyQueries.Add(1)
+		}
+		m.Answer = records[q.Name+" "+dns.TypeToString[q.Qtype]]
+		_ = w.WriteMsg(m)
+	})
+	setupResolver(t, upstream, upstream)
+	dnssecEnabled, trustAnchors = true, []*dns.DS{ds}
+	t.Cleanup(func() { dnssecEnabled, trustAnchors = false, nil })
+
+	ip, err := directDNS("www.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ip.String())
+	assert.Equal(t, StatusSecure, DNSSECStatus("www.example.test"))
+
+	for _, domain := range []string{"bad.example.test", "nosig.example.test"} {
+		_, err = directDNS(domain)
+		assert.Error(t, err, domain)
+		assert.Equal(t, StatusBogus, DNSSECStatus(domain), domain)
+	}
+	// the verified DNSKEY is cached within its TTL
+	assert.EqualValues(t, 1, dnskeyQueries.Load())
+
+	// not under any trust anchor
+	trustAnchors = []*dns.DS{{Hdr: dns.RR_Header{Name: "other.test."}}}
+	_, err = directDNS("nosig.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, StatusInsecure, DNSSECStatus("nosig.example.test"))
+}
+
+func serveRecords(t *testing.T, records map[string][]dns.RR, extra []dns.RR) netip.AddrPort {
+	return startServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		m.Answer = records[q.Name+" "+dns.TypeToString[q.Qtype]]
+		if q.Qtype == dns.TypeNS {
+			m.Extra = extra
+		}
+		_ = w.WriteMsg(m)
+	})
+}
+
+func TestDNSSECBogusAuthoritative(t *testing.T) {
+	z := newTestZone(t)
+	upstream := serveRecords(t, map[string][]dns.RR{
+		"example.test. DNSKEY": z.sign(z.key),
+		"example.test. NS": z.sign(&dns.NS{
+			Hdr: dns.RR_Header{Name: "example.test.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
+			Ns:  "ns1.example.test.",
+		}),
+		"www.example.test. A": z.sign(testA("www.example.test.", "192.0.2.1")),
+	}, []dns.RR{testA("ns1.example.test.", "127.0.0.1")})
+	forged := z.sign(testA("www.example.test.", "192.0.2.1"))
+	forged[0] = testA("www.example.test.", "203.0.113.66")
+	authoritative := serveRecords(t, map[string][]dns.RR{"www.example.test. A": forged}, nil)
+	setupResolver(t, upstream, authoritative)
+	dnssecEnabled, trustAnchors = true, []*dns.DS{z.key.ToDS(dns.SHA256)}
+	t.Cleanup(func() { dnssecEnabled, trustAnchors = false, nil })
+
+	// the valid answer from upstream must not be taken instead
+	_, err := directDNS("www.example.test")
+	assert.Error(t, err)
+	assert.Equal(t, StatusBogus, DNSSECStatus("www.example.test"))
+}
+
+func TestVerifyNoDS(t *testing.T) {
+	z := newTestZone(t)
+	keys := []*dns.DNSKEY{z.key}
+	nsec3 := func(owner string, optOut bool, next string, types ...uint16) *dns.NSEC3 {
+		var flags uint8
+		if optOut {
+			flags = 1
+		}
+		return &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 60},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			NextDomain: next,
+			TypeBitMap: types,
+		}
+	}
+	hashed := func(name string) string {
+		return dns.HashName(name, dns.SHA1, 0, "") + ".example.test."
+	}
+	first, last := strings.Repeat("0", 32), strings.Repeat("V", 32)
+	// an opt-out span covering every hash, including the child
+	optOut := z.sign(nsec3(first+".example.test.", true, last))
+	apex := z.sign(nsec3(hashed("example.test."), false, last, dns.TypeNS, dns.TypeSOA, dns.TypeDNSKEY))
+
+	testcases := []struct {
+		name  string
+		ns    []dns.RR
+		valid bool
+	}{
+		{"matched delegation", z.sign(nsec3(hashed("child.example.test."), false, last, dns.TypeNS)), true},
+		{"matched with DS", z.sign(nsec3(hashed("child.example.test."), false, last, dns.TypeNS, dns.TypeDS)), false},
+		{"closest encloser proof", append(slices.Clone(apex), optOut...), true},
+		{"opt-out without closest encloser", optOut, false},
+		{"closest encloser without opt-out", append(slices.Clone(apex), z.sign(nsec3(first+".example.test.", false, last))...), false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyNoDS(&dns.Msg{Ns: tc.ns}, "child.example.test.", keys, "example.test.")
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ package dns
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
 	"net/netip"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,36 +16,73 @@ import (
 	"golang.org/x/time/rate"
 )
 
-var globalRateLimiter = rate.NewLimiter(rate.Every(time.Millisecond*20), 1)
+var (
+	globalRateLimiter = rate.NewLimiter(rate.Every(time.Millisecond*20), 1)
+	queryRetry        = 3
+)
+
+// RcodeError is returned when a DNS server answers with a rcode meaning it cannot serve the query,
+// the next server should be tried
+type RcodeError struct {
+	Server netip.AddrPort
+	Rcode  int
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("dns server %s failure with rcode %s", e.Server, dns.RcodeToString[e.Rcode])
+}
 
 // server is an address with port but not only domain name
 func queryWithRetry(ctx context.Context, domain string, qType uint16, server netip.AddrPort) (*dns.Msg, error) {
 	msg := new(dns.Msg)
 	msg.SetQuestion(domain, qType)
+	if dnssecEnabled {
+		msg.SetEdns0(4096, true)
+	}
 	var rec *dns.Msg
 
 	// only retry on exchange error, not for response error
-	err := <-utils.GoRetryCtx(ctx, 3, 50*time.Millisecond, func(ctx context.Context) (err error) {
+	err := <-utils.GoRetryCtx(ctx, queryRetry, 50*time.Millisecond, func(ctx context.Context) (err error) {
 		if err := globalRateLimiter.Wait(ctx); err != nil {
 			return err
 		}
-		rec, _, err = defaultDNSClient.ExchangeContext(ctx, msg, server.String())
+		var rtt time.Duration
+		rec, rtt, err = defaultDNSClient.ExchangeContext(ctx, msg, server.String())
+		traceFrom(ctx).addQuery(domain, qType, server, rec, rtt, err)
 		if err != nil {
 			log.Warn().Str("domain", domain).Err(err).Str("server", server.String()).Msg("DNS lookup failed")
 			return err
 		}
-		if rec.Rcode != dns.RcodeSuccess {
-			log.Warn().Msgf("dns server %s failure with rcode %d", server, rec.Rcode)
+		if rec.Truncated {
+			log.Debug().Str("domain", domain).Str("server", server.String()).Msg("DNS answer truncated, retry over TCP")
+			if err := globalRateLimiter.Wait(ctx); err != nil {
+				return err
+			}
+			rec, rtt, err = tcpDNSClient.ExchangeContext(ctx, msg, server.String())
+			traceFrom(ctx).addQuery(domain, qType, server, rec, rtt, err)
+			if err != nil {
+				log.Warn().Str("domain", domain).Err(err).Str("server", server.String()).Msg("DNS lookup over TCP failed")
+				return err
+			}
 		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
+
+	switch rec.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	case dns.RcodeServerFailure, dns.RcodeRefused:
+		return nil, &RcodeError{Server: server, Rcode: rec.Rcode}
+	default:
+		log.Warn().Msgf("dns server %s failure with rcode %d", server, rec.Rcode)
+	}
 	return rec, nil
 }
 
 func queryWithRetryWithList(ctx context.Context, domain string, qType uint16, dnsList []netip.AddrPort) (*dns.Msg, error) {
+	var errs []error
 	for _, s := range dnsList {
 		msg, err := queryWithRetry(ctx, domain, qType, s)
 		if err != nil {
@@ -50,76 +90,81 @@ func queryWithRetryWithList(ctx context.Context, domain string, qType uint16, dn
 				return nil, err
 			}
 			log.Debug().Err(err).Str("domain", domain).Str("server", s.String()).Msg("failed to resolve")
+			errs = append(errs, err)
 			continue
 		}
 		return msg, nil
 	}
-	return nil, errors.New("failed to resolve with all server")
+	return nil, fmt.Errorf("failed to resolve with all server: %w", errors.Join(errs...))
 }
 
-func queryAAndAAAAAddrIter(domain string, dnsList []netip.AddrPort) func(yield func(addr netip.Addr) bool) {
-	return func(yield func(addr netip.Addr) bool) {
-		var (
-			wg         sync.WaitGroup
-			resultChan = make(chan *dns.Msg, 2)
-		)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+// queryAddrs queries both A and AAAA records of domain, IPv4 addresses come first
+func queryAddrs(ctx context.Context, domain string, dnsList []netip.AddrPort) ([]netip.Addr, error) {
+	addrs, _, err := lookupAddrs(ctx, domain, dnsList)
+	return addrs, err
+}
 
+// lookupAddrs is like queryAddrs, but also validates the answers if DNSSEC is enabled
+func lookupAddrs(ctx context.Context, domain string, dnsList []netip.AddrPort) ([]netip.Addr, ValidationStatus, error) {
+	var (
+		wg      sync.WaitGroup
+		results [2]*dns.Msg
+		errs    [2]error
+	)
+	qTypes := []uint16{dns.TypeA, dns.TypeAAAA}
+	for i, qType := range qTypes {
 		wg.Go(func() {
-			rec, err := queryWithRetryWithList(ctx, domain, dns.TypeA, dnsList)
-			if err != nil {
-				if !errors.Is(err, context.Canceled) {
-					log.Err(err).Msgf("DNS query failed")
-				}
-				return
-			}
-			resultChan <- rec
+			results[i], errs[i] = queryWithRetryWithList(ctx, domain, qType, dnsList)
 		})
+	}
+	wg.Wait()
 
-		wg.Go(func() {
-			rec, err := queryWithRetryWithList(ctx, domain, dns.TypeAAAA, dnsList)
-			if err != nil {
-				if !errors.Is(err, context.Canceled) {
-					log.Err(err).Msgf("DNS query failed")
-				}
-				return
-			}
-			resultChan <- rec
-		})
+	var (
+		addrs []netip.Addr
+		msgs  []*dns.Msg
+	)
+	for _, rec := range results {
+		if rec != nil {
+			addrs = append(addrs, addrsFromRR(rec.Answer, "")...)
+			msgs = append(msgs, rec)
+		}
+	}
+	if len(addrs) == 0 {
+		if err := errors.Join(errs[:]...); err != nil {
+			return nil, StatusUnknown, err
+		}
+		return nil, StatusUnknown, fmt.Errorf("no address found for %s", domain)
+	}
 
-		go func() {
-			wg.Wait()
-			close(resultChan)
-		}()
+	status, err := validateAnswer(ctx, domain, qTypes, msgs...)
+	if err != nil {
+		return nil, status, err
+	}
+	return addrs, status, nil
+}
 
-		select {
-		case <-ctx.Done():
-			return
-		case result, ok := <-resultChan:
-			if !ok {
-				return
-			}
-			for _, rr := range result.Answer {
-				switch rr := rr.(type) {
-				case *dns.A:
-					addr, ok := netip.AddrFromSlice(rr.A)
-					if !ok {
-						log.Warn().Str("rr", rr.String()).Msgf("convert dns response to netip")
-					}
-					if !yield(addr) {
-						return
-					}
-				case *dns.AAAA:
-					addr, ok := netip.AddrFromSlice(rr.AAAA)
-					if !ok {
-						log.Warn().Str("rr", rr.String()).Msgf("convert dns response to netip")
-					}
-					if !yield(addr) {
-						return
-					}
-				}
-			}
+// addrsFromRR collects addresses from A and AAAA records, filter by owner name if name is not empty
+func addrsFromRR(rrs []dns.RR, name string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range rrs {
+		if name != "" && !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			log.Warn().Str("rr", rr.String()).Msgf("convert dns response to netip")
+			continue
 		}
+		addrs = append(addrs, addr.Unmap())
 	}
+	return addrs
 }
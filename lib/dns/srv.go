@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+// LookupSRV returns SRV records of name ordered by priority and weight, like net.LookupSRV
+var LookupSRV = lookupSRVSystem
+
+func lookupSRVSystem(name string) ([]*net.SRV, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	return addrs, err
+}
+
+// IsSRVName reports whether endpoint is a SRV owner name like _wireguard._udp.peer.example.dn11
+func IsSRVName(endpoint string) bool {
+	if _, _, err := net.SplitHostPort(endpoint); err == nil {
+		return false
+	}
+	labels := strings.SplitN(endpoint, ".", 3)
+	return len(labels) == 3 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_")
+}
+
+// ResolveEndpoint resolves a peer endpoint, either host:port or a SRV name
+func ResolveEndpoint(endpoint string) (*net.UDPAddr, error) {
+	if !IsSRVName(endpoint) {
+		return ResolveUDPAddr("", endpoint)
+	}
+	return ResolveSRV(endpoint)
+}
+
+// ResolveSRV looks up SRV records of name and resolves the targets in order, returns the first resolvable one
+func ResolveSRV(name string) (*net.UDPAddr, error) {
+	return resolveSRV(name, LookupSRV, func(addr string) (*net.UDPAddr, error) {
+		return ResolveUDPAddr("", addr)
+	})
+}
+
+// ResolveSRVSystem is ResolveSRV with the system resolver, whether the direct resolver is enabled or not
+func ResolveSRVSystem(name string) (*net.UDPAddr, error) {
+	return resolveSRV(name, lookupSRVSystem, func(addr string) (*net.UDPAddr, error) {
+		return net.ResolveUDPAddr("udp", addr)
+	})
+}
+
+// ResolveSRVDirectContext is ResolveSRV with the direct resolver, whether it's enabled or not.
+// ctx could carry a Trace by WithTrace
+func ResolveSRVDirectContext(ctx context.Context, name string) (*net.UDPAddr, error) {
+	addr, err := resolveSRV(name, func(name string) ([]*net.SRV, error) {
+		return lookupSRVDirectContext(ctx, name)
+	}, func(addr string) (*net.UDPAddr, error) {
+		return ResolveUDPAddrDirectContext(ctx, addr)
+	})
+	if err == nil {
+		// of the SRV records and the target together
+		traceFrom(ctx).setDNSSEC(DNSSECStatus(name))
+	}
+	return addr, err
+}
+
+func resolveSRV(name string, lookup func(name string) ([]*net.SRV, error), resolve func(addr string) (*net.UDPAddr, error)) (*net.UDPAddr, error) {
+	records, err := lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup SRV %s failed: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no SRV record found for %s", name)
+	}
+
+	var errs []error
+	for _, srv := range records {
+		target := strings.TrimSuffix(srv.Target, ".")
+		// RFC 2782: a target of "." means the service is decidedly not available
+		if target == "" {
+			return nil, fmt.Errorf("service %s is not available", name)
+		}
+		addr, err := resolve(net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		if err != nil {
+			log.Debug().Err(err).Str("srv", name).Str("target", target).Msg("resolve SRV target failed, try next")
+			errs = append(errs, err)
+			continue
+		}
+		if dnssecEnabled {
+			setDNSSECStatus(name, DNSSECStatus(name).combine(DNSSECStatus(target)))
+		}
+		return addr, nil
+	}
+	return nil, fmt.Errorf("resolve SRV targets of %s failed: %w", name, errors.Join(errs...))
+}
+
+func lookupSRVDirect(name string) ([]*net.SRV, error) {
+	return lookupSRVDirectContext(context.Background(), name)
+}
+
+func lookupSRVDirectContext(ctx context.Context, name string) ([]*net.SRV, error) {
+	rec, err := queryWithRetryWithList(ctx, dns.Fqdn(name), dns.TypeSRV, publicDNS)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("SRV query failed with rcode %s", dns.RcodeToString[rec.Rcode])
+	}
+	status, err := validateAnswer(ctx, dns.Fqdn(name), []uint16{dns.TypeSRV}, rec)
+	setDNSSECStatus(name, status)
+	if err != nil {
+		log.Error().Err(err).Str("srv", name).Str("dnssec", string(status)).Msg("DNSSEC validation failed, answer rejected")
+		return nil, err
+	}
+
+	var records []*net.SRV
+	for _, rr := range rec.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		records = append(records, &net.SRV{
+			Target:   srv.Target,
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+	}
+	sortSRV(records)
+	return records, nil
+}
+
+// sortSRV orders records by priority, and randomly by weight within the same priority (RFC 2782)
+func sortSRV(records []*net.SRV) {
+	slices.SortStableFunc(records, func(a, b *net.SRV) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+
+	for start := 0; start < len(records); {
+		end := start + 1
+		for end < len(records) && records[end].Priority == records[start].Priority {
+			end++
+		}
+		shuffleByWeight(records[start:end])
+		start = end
+	}
+}
+
+func shuffleByWeight(records []*net.SRV) {
+	sum := 0
+	for _, srv := range records {
+		sum += int(srv.Weight)
+	}
+	for sum > 0 && len(records) > 1 {
+		n := rand.Intn(sum + 1)
+		s := 0
+		for i, srv := range records {
+			s += int(srv.Weight)
+			if s >= n {
+				if i > 0 {
+					records[0], records[i] = records[i], records[0]
+				}
+				break
+			}
+		}
+		sum -= int(records[0].Weight)
+		records = records[1:]
+	}
+}
@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"maps"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSRVName(t *testing.T) {
+	assert.True(t, IsSRVName("_wireguard._udp.peer.example.dn11"))
+	assert.False(t, IsSRVName("peer.example.dn11"))
+	assert.False(t, IsSRVName("_wireguard._udp.peer.example.dn11:51820"))
+	assert.False(t, IsSRVName("1.1.1.1:51820"))
+}
+
+func TestSortSRV(t *testing.T) {
+	for range 20 {
+		records := []*net.SRV{
+			{Target: "c.", Priority: 20, Weight: 10},
+			{Target: "a1.", Priority: 10, Weight: 0},
+			{Target: "b.", Priority: 15, Weight: 5},
+			{Target: "a2.", Priority: 10, Weight: 100},
+		}
+		sortSRV(records)
+		assert.Contains(t, []string{"a1.", "a2."}, records[0].Target)
+		assert.Contains(t, []string{"a1.", "a2."}, records[1].Target)
+		assert.Equal(t, "b.", records[2].Target)
+		assert.Equal(t, "c.", records[3].Target)
+	}
+}
+
+func TestResolveSRVDirect(t *testing.T) {
+	records := maps.Clone(upstreamRecords)
+	records["_wireguard._udp.peer.example.test. SRV"] = "an _wireguard._udp.peer.example.test. 60 IN SRV 10 0 51821 www.example.test."
+	upstream := startServer(t, zoneHandler(dns.RcodeRefused, records))
+	authoritative := startServer(t, zoneHandler(dns.RcodeRefused, authoritativeRecords))
+	setupResolver(t, upstream, authoritative)
+
+	trace := &Trace{}
+	addr, err := ResolveSRVDirectContext(WithTrace(t.Context(), trace), "_wireguard._udp.peer.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:51821", addr.String())
+	assert.Equal(t, "_wireguard._udp.peer.example.test.", trace.Queries[0].Name)
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Trace records what the direct resolver does, used for debugging
+type Trace struct {
+	CNAMEs  []string
+	NS      []netip.AddrPort
+	Queries []TraceQuery
+	DNSSEC  ValidationStatus
+
+	lock sync.Mutex
+}
+
+// TraceQuery is a single DNS exchange
+type TraceQuery struct {
+	Name   string
+	Type   string
+	Server netip.AddrPort
+	Rcode  string
+	// TTL is the min TTL in the answer section, 0 if no answer
+	TTL     uint32
+	Answers int
+	RTT     time.Duration
+	Err     error
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context which records resolution details into trace
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+func (t *Trace) addQuery(domain string, qType uint16, server netip.AddrPort, rec *dns.Msg, rtt time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	q := TraceQuery{
+		Name:   domain,
+		Type:   dns.TypeToString[qType],
+		Server: server,
+		RTT:    rtt,
+		Err:    err,
+	}
+	if rec != nil {
+		q.Rcode = dns.RcodeToString[rec.Rcode]
+		q.Answers = len(rec.Answer)
+		for i, rr := range rec.Answer {
+			if i == 0 || rr.Header().Ttl < q.TTL {
+				q.TTL = rr.Header().Ttl
+			}
+		}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Queries = append(t.Queries, q)
+}
+
+func (t *Trace) addCNAME(target string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.CNAMEs = append(t.CNAMEs, target)
+}
+
+func (t *Trace) setNS(servers []netip.AddrPort) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.NS = servers
+}
+
+func (t *Trace) setDNSSEC(status ValidationStatus) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.DNSSEC = status
+}
@@ -0,0 +1,123 @@
+// Package minisign verifies signatures made by minisign (https://jedisct1.github.io/minisign/)
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// algorithmPure signs the message itself, used by legacy minisign
+	algorithmPure = "Ed"
+	// algorithmHashed signs the BLAKE2b-512 hash of the message, the default since minisign 0.8
+	algorithmHashed = "ED"
+)
+
+type PublicKey struct {
+	KeyID [8]byte
+	Key   ed25519.PublicKey
+}
+
+type Signature struct {
+	Algorithm      string
+	KeyID          [8]byte
+	Signature      []byte
+	TrustedComment string
+	GlobalSig      []byte
+}
+
+// ParsePublicKey parses the base64 public key, or the content of a minisign .pub file
+func ParsePublicKey(text string) (*PublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	b, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return nil, fmt.Errorf("decode public key failed: %w", err)
+	}
+	if len(b) != 2+8+ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(b))
+	}
+	if string(b[:2]) != algorithmPure {
+		return nil, fmt.Errorf("unsupported public key algorithm %q", b[:2])
+	}
+	pk := &PublicKey{Key: ed25519.PublicKey(b[10:])}
+	copy(pk.KeyID[:], b[2:10])
+	return pk, nil
+}
+
+// ParseSignature parses the content of a minisign .minisig file
+func ParseSignature(text []byte) (*Signature, error) {
+	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
+	if len(lines) != 4 {
+		return nil, fmt.Errorf("invalid signature, expected 4 lines but got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, errors.New("invalid signature, missing untrusted comment")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("decode signature failed: %w", err)
+	}
+	if len(b) != 2+8+ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature length %d", len(b))
+	}
+	trusted, ok := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ok {
+		return nil, errors.New("invalid signature, missing trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return nil, fmt.Errorf("decode global signature failed: %w", err)
+	}
+	if len(global) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid global signature length %d", len(global))
+	}
+
+	sig := &Signature{
+		Algorithm:      string(b[:2]),
+		Signature:      b[10:],
+		TrustedComment: trusted,
+		GlobalSig:      global,
+	}
+	copy(sig.KeyID[:], b[2:10])
+	return sig, nil
+}
+
+// Verify verifies the signature of msg, including the trusted comment
+func (pk *PublicKey) Verify(msg []byte, sig *Signature) error {
+	if sig.KeyID != pk.KeyID {
+		return fmt.Errorf("signed by key %s, but the public key is %s", keyID(sig.KeyID), keyID(pk.KeyID))
+	}
+	switch sig.Algorithm {
+	case algorithmPure:
+	case algorithmHashed:
+		hash := blake2b.Sum512(msg)
+		msg = hash[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	if !ed25519.Verify(pk.Key, msg, sig.Signature) {
+		return errors.New("signature mismatch")
+	}
+	global := bytes.Join([][]byte{sig.Signature, []byte(sig.TrustedComment)}, nil)
+	if !ed25519.Verify(pk.Key, global, sig.GlobalSig) {
+		return errors.New("trusted comment signature mismatch")
+	}
+	return nil
+}
+
+// keyID formats key ID as minisign does, in little endian hex
+func keyID(id [8]byte) string {
+	reversed := id
+	for i := range 4 {
+		reversed[i], reversed[7-i] = reversed[7-i], reversed[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(reversed[:]))
+}
@@ -0,0 +1,70 @@
+package minisign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+var testKeyID = [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+func testKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	b := append([]byte("Ed"), testKeyID[:]...)
+	b = append(b, pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(b) + "\n", priv
+}
+
+func sign(priv ed25519.PrivateKey, algorithm string, msg []byte, trusted string) []byte {
+	if algorithm == algorithmHashed {
+		hash := blake2b.Sum512(msg)
+		msg = hash[:]
+	}
+	sig := ed25519.Sign(priv, msg)
+	b := append([]byte(algorithm), testKeyID[:]...)
+	b = append(b, sig...)
+	global := ed25519.Sign(priv, append(sig, trusted...))
+	return fmt.Appendf(nil, "untrusted comment: signature\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(b), trusted, base64.StdEncoding.EncodeToString(global))
+}
+
+func TestVerify(t *testing.T) {
+	pubText, priv := testKey(t)
+	pk, err := ParsePublicKey(pubText)
+	require.NoError(t, err)
+	assert.Equal(t, testKeyID, pk.KeyID)
+	assert.Equal(t, "0807060504030201", keyID(pk.KeyID))
+
+	msg := []byte("sha256  wg-quick-op_Linux_x86_64.tar.gz\n")
+	for _, algorithm := range []string{algorithmPure, algorithmHashed} {
+		sig, err := ParseSignature(sign(priv, algorithm, msg, "timestamp:1700000000"))
+		require.NoError(t, err, algorithm)
+		assert.Equal(t, "timestamp:1700000000", sig.TrustedComment)
+		assert.NoError(t, pk.Verify(msg, sig), algorithm)
+
+		// tampered message
+		assert.Error(t, pk.Verify(append(msg, ' '), sig), algorithm)
+
+		// tampered trusted comment
+		sig.TrustedComment = "timestamp:1800000000"
+		assert.Error(t, pk.Verify(msg, sig), algorithm)
+	}
+
+	// signed by another key
+	_, other := testKey(t)
+	sig, err := ParseSignature(sign(other, algorithmHashed, msg, "x"))
+	require.NoError(t, err)
+	assert.Error(t, pk.Verify(msg, sig))
+
+	_, err = ParseSignature([]byte("untrusted comment: x\n"))
+	assert.Error(t, err)
+	_, err = ParsePublicKey("not base64")
+	assert.Error(t, err)
+}
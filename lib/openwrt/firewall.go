@@ -0,0 +1,141 @@
+package openwrt
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// zoneOf returns the firewall zone of iface, following openwrt.firewall.fwmap and default
+func zoneOf(iface string) string {
+	if zone, ok := conf.OpenWrt.Firewall.FwMap[iface]; ok {
+		return zone
+	}
+	return conf.OpenWrt.Firewall.Default
+}
+
+func firewallEnabled() bool {
+	return conf.OpenWrt.Firewall.Default != "" || len(conf.OpenWrt.Firewall.FwMap) > 0
+}
+
+// zoneMember returns the option and value to put iface into a zone, the UCI interface if
+// uci_iface is enabled, or the device itself
+func zoneMember(iface string) (string, string) {
+	if conf.OpenWrt.UCIIface {
+		return "network", UCIName(iface)
+	}
+	return "device", iface
+}
+
+// markerOption lists members added by us in a zone, so only they are removed later,
+// or marks an interface exported by us in network
+const markerOption = "wg_quick_op"
+
+func attachFirewall(iface string) error {
+	zoneName := zoneOf(iface)
+	if zoneName == "" {
+		return nil
+	}
+	key, member := zoneMember(iface)
+
+	var changed bool
+	err := uci.Edit(ConfDir, "firewall", func(firewall *uci.Config) (bool, error) {
+		var found bool
+		for _, zone := range firewall.SectionsByType("zone") {
+			members := zoneList(zone, key)
+			if zone.Get("name") == zoneName {
+				found = true
+				// a member configured by hand is left unmarked
+				if !slices.Contains(members, member) {
+					zone.SetList(key, append(members, member))
+					zone.AddList(markerOption, member)
+					changed = true
+				}
+				continue
+			}
+			if !slices.Contains(members, member) {
+				continue
+			}
+			// a link belongs to only one zone, move it out of the zone we put it in before
+			if !slices.Contains(zone.GetList(markerOption), member) {
+				log.Warn().Str("iface", iface).Str("zone", zone.Get("name")).Msg("also in firewall zone not managed by wg-quick-op, skip removing")
+				continue
+			}
+			removeMember(zone, key, member)
+			changed = true
+		}
+		if !found {
+			return false, fmt.Errorf("firewall zone %s not found", zoneName)
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Debug().Str("iface", iface).Str("zone", zoneName).Msg("already in firewall zone")
+		return nil
+	}
+	firewallChanged = true
+	log.Info().Str("iface", iface).Str("zone", zoneName).Msg("added to firewall zone")
+	return nil
+}
+
+func detachFirewall(iface string) error {
+	if !firewallEnabled() {
+		return nil
+	}
+	key, member := zoneMember(iface)
+
+	var changed bool
+	err := uci.Edit(ConfDir, "firewall", func(firewall *uci.Config) (bool, error) {
+		for _, zone := range firewall.SectionsByType("zone") {
+			if !slices.Contains(zone.GetList(markerOption), member) {
+				continue
+			}
+			removeMember(zone, key, member)
+			changed = true
+			log.Info().Str("iface", iface).Str("zone", zone.Get("name")).Msg("removed from firewall zone")
+		}
+		return changed, nil
+	})
+	if err != nil || !changed {
+		return err
+	}
+	firewallChanged = true
+	return nil
+}
+
+// removeMember removes member added by us from zone, with its marker
+func removeMember(zone *uci.Section, key, member string) {
+	zone.SetList(key, slices.DeleteFunc(zoneList(zone, key), func(m string) bool { return m == member }))
+	zone.DelList(markerOption, member)
+}
+
+// zoneList returns members of a zone, old configs may use a space separated option instead of list
+func zoneList(zone *uci.Section, key string) []string {
+	var members []string
+	for _, v := range zone.GetList(key) {
+		members = append(members, strings.Fields(v)...)
+	}
+	return members
+}
+
+func reloadFirewall() {
+	name, args := "fw4", []string{"reload"}
+	if _, err := exec.LookPath("fw4"); err != nil {
+		name, args = "/etc/init.d/firewall", []string{"reload"}
+	}
+	output, exitCode, err := utils.RunCommand(name, args...)
+	if err != nil || exitCode != 0 {
+		log.Error().Err(err).Int("exitCode", exitCode).Str("output", output).Msgf("failed to run '%s reload'", name)
+		return
+	}
+	log.Info().Msg("firewall reloaded")
+}
@@ -0,0 +1,102 @@
+package openwrt
+
+import (
+	"testing"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFirewall = `config zone
+	option name 'lan'
+	option network 'lan'
+
+config zone
+	option name 'dn11'
+	option network 'dn11_a dn11_b'
+
+config zone
+	option name 'dn22'
+`
+
+func TestFirewallZone(t *testing.T) {
+	setupConfDir(t, map[string]string{"firewall": testFirewall})
+	conf.OpenWrt.Firewall.Default = "dn11"
+	conf.OpenWrt.Firewall.FwMap = map[string]string{"if0": "dn22"}
+	t.Cleanup(func() {
+		conf.OpenWrt.Firewall.Default = ""
+		conf.OpenWrt.Firewall.FwMap = nil
+	})
+
+	zones := func() map[string][]string {
+		firewall, err := uci.Load(ConfDir, "firewall")
+		require.NoError(t, err)
+		m := make(map[string][]string)
+		for _, zone := range firewall.SectionsByType("zone") {
+			m[zone.Get("name")] = zoneList(zone, "network")
+		}
+		return m
+	}
+
+	require.NoError(t, Attach("tuntun"))
+	require.NoError(t, Attach("if0"))
+	// attach twice is a no-op
+	require.NoError(t, Attach("if0"))
+	assert.Equal(t, map[string][]string{
+		"lan":  {"lan"},
+		"dn11": {"dn11_a", "dn11_b", "tun00"},
+		"dn22": {"if0"},
+	}, zones())
+
+	require.NoError(t, Detach("tuntun"))
+	require.NoError(t, Detach("if0"))
+	assert.Equal(t, map[string][]string{
+		"lan":  {"lan"},
+		"dn11": {"dn11_a", "dn11_b"},
+		"dn22": nil,
+	}, zones())
+
+	conf.OpenWrt.Firewall.Default = "not-existed"
+	assert.Error(t, Attach("tuntun"))
+}
+
+func TestFirewallUnmanaged(t *testing.T) {
+	setupConfDir(t, map[string]string{"firewall": testFirewall})
+	conf.OpenWrt.Firewall.Default = "dn22"
+	t.Cleanup(func() { conf.OpenWrt.Firewall.Default = "" })
+
+	// dn11_a is put in dn11 by hand, and stays there
+	require.NoError(t, Attach("dn11_a"))
+	require.NoError(t, Detach("dn11_a"))
+	firewall, err := uci.Load(ConfDir, "firewall")
+	require.NoError(t, err)
+	zones := firewall.SectionsByType("zone")
+	assert.Equal(t, []string{"dn11_a", "dn11_b"}, zoneList(zones[1], "network"))
+	assert.Nil(t, zones[2].GetList("network"))
+	assert.Nil(t, zones[2].GetList(markerOption))
+
+	// moved out of the zone put in by us only
+	require.NoError(t, Attach("tuntun"))
+	conf.OpenWrt.Firewall.Default = "lan"
+	require.NoError(t, Attach("tuntun"))
+	firewall, err = uci.Load(ConfDir, "firewall")
+	require.NoError(t, err)
+	zones = firewall.SectionsByType("zone")
+	assert.Equal(t, []string{"lan", "tun00"}, zoneList(zones[0], "network"))
+	assert.Equal(t, []string{"tun00"}, zones[0].GetList(markerOption))
+	assert.Nil(t, zones[2].GetList("network"))
+}
+
+func TestFirewallDevice(t *testing.T) {
+	setupConfDir(t, map[string]string{"firewall": testFirewall})
+	conf.OpenWrt.UCIIface = false
+	conf.OpenWrt.Firewall.Default = "dn22"
+	t.Cleanup(func() { conf.OpenWrt.Firewall.Default = "" })
+
+	require.NoError(t, Attach("tuntun"))
+	firewall, err := uci.Load(ConfDir, "firewall")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tuntun"}, firewall.SectionsByType("zone")[2].GetList("device"))
+}
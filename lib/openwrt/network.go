@@ -0,0 +1,140 @@
+// Package openwrt integrates WireGuard links managed by wg-quick-op with OpenWrt netifd via UCI
+package openwrt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/dn-11/wg-quick-op/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// ConfDir is the UCI config directory
+var ConfDir = "/etc/config"
+
+var (
+	lock            sync.Mutex
+	networkChanged  bool
+	firewallChanged bool
+)
+
+// UCIName returns the UCI interface name of a WireGuard link, following openwrt.namemap
+func UCIName(iface string) string {
+	if name, ok := conf.OpenWrt.NameMap[iface]; ok && name != "" {
+		return name
+	}
+	// UCI section names only allow [a-zA-Z0-9_]
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, iface)
+}
+
+// Attach creates UCI interface network.<name> with proto none for the WireGuard link, so netifd and
+// LuCI see it, and adds it to the firewall zone. An existing section of the name not created by us is
+// never touched. Changes take effect after Reload
+func Attach(iface string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if conf.OpenWrt.UCIIface {
+		if err := attachNetwork(iface); err != nil {
+			return err
+		}
+	}
+	return attachFirewall(iface)
+}
+
+// Detach removes what Attach added. Changes take effect after Reload
+func Detach(iface string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if conf.OpenWrt.UCIIface {
+		if err := detachNetwork(iface); err != nil {
+			return err
+		}
+	}
+	return detachFirewall(iface)
+}
+
+func attachNetwork(iface string) error {
+	name := UCIName(iface)
+	var added bool
+	err := uci.Edit(ConfDir, "network", func(network *uci.Config) (bool, error) {
+		section := network.Section(name)
+		if section != nil {
+			if !managed(section, iface) {
+				return false, fmt.Errorf("UCI section network.%s exists and is not managed by wg-quick-op, set openwrt.namemap to use another name", name)
+			}
+			log.Debug().Str("iface", iface).Str("uci", name).Msg("UCI interface present")
+			return false, nil
+		}
+		section = network.Add("interface", name)
+		section.Set("proto", "none")
+		section.Set("device", iface)
+		added = true
+		return true, nil
+	})
+	if err != nil || !added {
+		return err
+	}
+	networkChanged = true
+	log.Info().Str("iface", iface).Str("uci", name).Msg("UCI interface added")
+	return nil
+}
+
+func detachNetwork(iface string) error {
+	name := UCIName(iface)
+	var removed bool
+	err := uci.Edit(ConfDir, "network", func(network *uci.Config) (bool, error) {
+		section := network.Section(name)
+		if section == nil {
+			return false, nil
+		}
+		if !managed(section, iface) {
+			log.Warn().Str("iface", iface).Str("uci", name).Msg("UCI interface is not managed by wg-quick-op, skip removing")
+			return false, nil
+		}
+		network.Delete(func(s *uci.Section) bool { return s == section })
+		removed = true
+		return true, nil
+	})
+	if err != nil || !removed {
+		return err
+	}
+	networkChanged = true
+	log.Info().Str("iface", iface).Str("uci", name).Msg("UCI interface removed")
+	return nil
+}
+
+// managed reports whether the UCI section looks like the interface created by us for iface
+func managed(section *uci.Section, iface string) bool {
+	return section.Type == "interface" && section.Get("proto") == "none" && section.Get("device") == iface
+}
+
+// Reload applies pending UCI changes made by Attach and Detach, batched for all interfaces
+func Reload() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if networkChanged {
+		networkChanged = false
+		output, exitCode, err := utils.RunCommand("ubus", "call", "network", "reload")
+		if err != nil || exitCode != 0 {
+			log.Error().Err(err).Int("exitCode", exitCode).Str("output", output).Msg("failed to run 'ubus call network reload'")
+		} else {
+			log.Info().Msg("network reloaded")
+		}
+	}
+
+	if firewallChanged {
+		firewallChanged = false
+		reloadFirewall()
+	}
+}
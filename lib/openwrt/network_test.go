@@ -0,0 +1,61 @@
+package openwrt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dn-11/wg-quick-op/conf"
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupConfDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	ConfDir = t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(ConfDir, name), []byte(content), 0644))
+	}
+	conf.OpenWrt.UCIIface = true
+	conf.OpenWrt.NameMap = map[string]string{"tuntun": "tun00"}
+	t.Cleanup(func() {
+		conf.OpenWrt.UCIIface = false
+		conf.OpenWrt.NameMap = nil
+	})
+}
+
+func TestAttachDetach(t *testing.T) {
+	setupConfDir(t, map[string]string{
+		"network": "config interface 'lan'\n\toption proto 'static'\n",
+	})
+
+	require.NoError(t, Attach("tuntun"))
+	require.NoError(t, Attach("dn-11"))
+	network, err := uci.Load(ConfDir, "network")
+	require.NoError(t, err)
+	require.NotNil(t, network.Section("tun00"))
+	assert.Equal(t, "none", network.Section("tun00").Get("proto"))
+	assert.Equal(t, "tuntun", network.Section("tun00").Get("device"))
+	assert.Equal(t, "dn-11", network.Section("dn_11").Get("device"))
+
+	require.NoError(t, Detach("tuntun"))
+	require.NoError(t, Detach("dn-11"))
+	// not managed by us
+	require.NoError(t, Detach("lan"))
+	network, err = uci.Load(ConfDir, "network")
+	require.NoError(t, err)
+	assert.Len(t, network.Sections, 1)
+	assert.NotNil(t, network.Section("lan"))
+}
+
+func TestAttachUnmanaged(t *testing.T) {
+	const existing = "config interface 'wg0'\n\toption proto 'wireguard'\n\toption private_key 'key'\n\tlist addresses '10.0.0.1/24'\n"
+	setupConfDir(t, map[string]string{"network": existing})
+
+	assert.Error(t, Attach("wg0"))
+	require.NoError(t, Detach("wg0"))
+	b, err := os.ReadFile(filepath.Join(ConfDir, "network"))
+	require.NoError(t, err)
+	assert.Equal(t, existing, string(b))
+}
@@ -0,0 +1,207 @@
+package openwrt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/dn-11/wg-quick-op/lib/wgconf"
+	"github.com/rs/zerolog/log"
+)
+
+// WireguardInterfaces returns names of the native WireGuard interfaces (luci-proto-wireguard) in network config
+func WireguardInterfaces(network *uci.Config) []string {
+	var names []string
+	for _, section := range network.SectionsByType("interface") {
+		if section.Get("proto") == "wireguard" && section.Name != "" {
+			names = append(names, section.Name)
+		}
+	}
+	return names
+}
+
+// ImportUCI converts a native WireGuard interface in network config to a wg-quick config
+func ImportUCI(network *uci.Config, iface string) ([]byte, error) {
+	section := network.Section(iface)
+	if section == nil || section.Get("proto") != "wireguard" {
+		return nil, fmt.Errorf("%s is not a WireGuard interface", iface)
+	}
+	logger := log.With().Str("iface", iface).Logger()
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# imported from UCI network.%s\n", iface)
+	b.WriteString("[Interface]\n")
+	for _, addr := range section.GetList("addresses") {
+		if !strings.Contains(addr, "/") {
+			if strings.Contains(addr, ":") {
+				addr += "/128"
+			} else {
+				addr += "/32"
+			}
+		}
+		fmt.Fprintf(b, "Address = %s\n", addr)
+	}
+	if section.Get("private_key") == "" {
+		return nil, errors.New("private_key is required")
+	}
+	fmt.Fprintf(b, "PrivateKey = %s\n", section.Get("private_key"))
+	// in order, so the output is stable
+	for _, o := range []struct{ option, directive string }{
+		{"listen_port", "ListenPort"},
+		{"mtu", "MTU"},
+		{"fwmark", "FwMark"},
+	} {
+		if v := section.Get(o.option); v != "" {
+			fmt.Fprintf(b, "%s = %s\n", o.directive, v)
+		}
+	}
+
+	peers := network.SectionsByType("wireguard_" + iface)
+	var routed, unrouted int
+	for _, peer := range peers {
+		if peer.Get("route_allowed_ips") == "1" {
+			routed++
+		} else {
+			unrouted++
+		}
+	}
+	if routed == 0 {
+		b.WriteString("Table = off\n")
+	} else if unrouted > 0 {
+		logger.Warn().Msg("route_allowed_ips is not set on all peers, routes of all peers will be added")
+	}
+
+	for _, peer := range peers {
+		b.WriteString("\n")
+		if desc := peer.Get("description"); desc != "" {
+			fmt.Fprintf(b, "# %s\n", desc)
+		}
+		if peer.Get("disabled") == "1" {
+			logger.Warn().Str("peer", peer.Get("public_key")).Msg("skip disabled peer")
+			fmt.Fprintf(b, "# disabled peer %s is skipped\n", peer.Get("public_key"))
+			continue
+		}
+		if peer.Get("public_key") == "" {
+			return nil, errors.New("public_key is required for peers")
+		}
+		b.WriteString("[Peer]\n")
+		fmt.Fprintf(b, "PublicKey = %s\n", peer.Get("public_key"))
+		if v := peer.Get("preshared_key"); v != "" {
+			fmt.Fprintf(b, "PresharedKey = %s\n", v)
+		}
+		if allowed := peer.GetList("allowed_ips"); len(allowed) > 0 {
+			fmt.Fprintf(b, "AllowedIPs = %s\n", strings.Join(allowed, ", "))
+		}
+		if host := peer.Get("endpoint_host"); host != "" {
+			port := peer.Get("endpoint_port")
+			if port == "" {
+				port = "51820"
+			}
+			fmt.Fprintf(b, "Endpoint = %s\n", net.JoinHostPort(host, port))
+		}
+		if v := peer.Get("persistent_keepalive"); v != "" && v != "0" {
+			fmt.Fprintf(b, "PersistentKeepalive = %s\n", v)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// ExportUCI writes a wg-quick config into network config as a native WireGuard interface, marked as exported by us.
+// An interface exported before is replaced with its peers, any other section of the name is only replaced if force
+func ExportUCI(network *uci.Config, iface string, wgConf []byte, force bool) error {
+	if UCIName(iface) != iface {
+		return fmt.Errorf("%s is not a valid UCI section name", iface)
+	}
+	logger := log.With().Str("iface", iface).Logger()
+
+	if old := network.Section(iface); old != nil && !force {
+		if old.Type != "interface" || old.Get("proto") != "wireguard" {
+			return fmt.Errorf("UCI section network.%s exists and is not a WireGuard interface, use --force to replace it", iface)
+		}
+		if old.Get(markerOption) != "1" {
+			return fmt.Errorf("UCI interface network.%s is not exported by wg-quick-op, use --force to replace it", iface)
+		}
+	}
+
+	sections, err := wgconf.Parse(wgConf)
+	if err != nil {
+		return err
+	}
+
+	// built aside, so network is untouched on error
+	exported := &uci.Config{}
+	section := exported.Add("interface", iface)
+	section.Set("proto", "wireguard")
+	section.Set(markerOption, "1")
+
+	route := "1"
+	for _, s := range sections {
+		if s.Name != wgconf.Interface {
+			continue
+		}
+		for _, d := range s.Directives {
+			switch d.Key {
+			case "PrivateKey":
+				section.Set("private_key", d.Value)
+			case "ListenPort":
+				section.Set("listen_port", d.Value)
+			case "MTU":
+				section.Set("mtu", d.Value)
+			case "FwMark":
+				section.Set("fwmark", d.Value)
+			case "Address":
+				for _, addr := range strings.Split(d.Value, ",") {
+					section.AddList("addresses", strings.TrimSpace(addr))
+				}
+			case "Table":
+				if strings.ToLower(d.Value) == "off" {
+					route = "0"
+				} else {
+					logger.Warn().Str("table", d.Value).Msg("routing table is not supported by UCI, routes go to the main table")
+				}
+			default:
+				logger.Warn().Str("directive", d.Key).Msg("directive is not supported by UCI, skipped")
+			}
+		}
+	}
+
+	for _, s := range sections {
+		if s.Name != wgconf.Peer {
+			continue
+		}
+		peer := exported.Add("wireguard_"+iface, "")
+		peer.Set("route_allowed_ips", route)
+		for _, d := range s.Directives {
+			switch d.Key {
+			case "PublicKey":
+				peer.Set("public_key", d.Value)
+			case "PresharedKey":
+				peer.Set("preshared_key", d.Value)
+			case "AllowedIPs":
+				for _, addr := range strings.Split(d.Value, ",") {
+					peer.AddList("allowed_ips", strings.TrimSpace(addr))
+				}
+			case "Endpoint":
+				host, port, err := net.SplitHostPort(d.Value)
+				if err != nil {
+					return fmt.Errorf("cannot parse endpoint %s: %w", d.Value, err)
+				}
+				peer.Set("endpoint_host", host)
+				peer.Set("endpoint_port", port)
+			case "PersistentKeepalive":
+				peer.Set("persistent_keepalive", d.Value)
+			default:
+				logger.Warn().Str("directive", d.Key).Msg("directive is not supported by UCI, skipped")
+			}
+		}
+	}
+
+	network.Delete(func(s *uci.Section) bool {
+		return s.Name == iface || s.Type == "wireguard_"+iface
+	})
+	network.Sections = append(network.Sections, exported.Sections...)
+	return nil
+}
@@ -0,0 +1,105 @@
+package openwrt
+
+import (
+	"testing"
+
+	"github.com/dn-11/wg-quick-op/lib/uci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testWireguardNetwork = `config interface 'lan'
+	option proto 'static'
+
+config interface 'wg0'
+	option proto 'wireguard'
+	option private_key 'cHJpdmF0ZQ=='
+	option listen_port '51820'
+	list addresses '10.0.0.1/24'
+	list addresses 'fd00::1'
+
+config wireguard_wg0
+	option description 'peer a'
+	option public_key 'cGVlcmE='
+	list allowed_ips '10.0.0.2/32'
+	list allowed_ips '10.1.0.0/16'
+	option endpoint_host 'a.example.com'
+	option endpoint_port '12345'
+	option persistent_keepalive '25'
+
+config wireguard_wg0
+	option public_key 'cGVlcmI='
+	option disabled '1'
+`
+
+func TestImportUCI(t *testing.T) {
+	network, err := uci.Parse([]byte(testWireguardNetwork))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wg0"}, WireguardInterfaces(network))
+
+	text, err := ImportUCI(network, "wg0")
+	require.NoError(t, err)
+	assert.Equal(t, `# imported from UCI network.wg0
+[Interface]
+Address = 10.0.0.1/24
+Address = fd00::1/128
+PrivateKey = cHJpdmF0ZQ==
+ListenPort = 51820
+Table = off
+
+# peer a
+[Peer]
+PublicKey = cGVlcmE=
+AllowedIPs = 10.0.0.2/32, 10.1.0.0/16
+Endpoint = a.example.com:12345
+PersistentKeepalive = 25
+
+# disabled peer cGVlcmI= is skipped
+`, string(text))
+
+	_, err = ImportUCI(network, "lan")
+	assert.Error(t, err)
+}
+
+func TestExportUCI(t *testing.T) {
+	network, err := uci.Parse([]byte(testWireguardNetwork))
+	require.NoError(t, err)
+
+	wgConf := []byte(`[Interface]
+Address = 10.0.0.1/24, fd00::1/64
+PrivateKey = bmV3
+PostUp = true
+
+[Peer]
+PublicKey = cGVlcmM=
+AllowedIPs = 0.0.0.0/0
+Endpoint = [2001:db8::1]:51820
+`)
+	// wg0 is configured by hand
+	assert.Error(t, ExportUCI(network, "wg0", wgConf, false))
+	assert.Len(t, network.SectionsByType("wireguard_wg0"), 2)
+	require.NoError(t, ExportUCI(network, "wg0", wgConf, true))
+	// exported by us from now on
+	require.NoError(t, ExportUCI(network, "wg0", wgConf, false))
+	iface := network.Section("wg0")
+	require.NotNil(t, iface)
+	assert.Equal(t, "bmV3", iface.Get("private_key"))
+	assert.Equal(t, []string{"10.0.0.1/24", "fd00::1/64"}, iface.GetList("addresses"))
+
+	peers := network.SectionsByType("wireguard_wg0")
+	require.Len(t, peers, 1)
+	assert.Equal(t, "cGVlcmM=", peers[0].Get("public_key"))
+	assert.Equal(t, "2001:db8::1", peers[0].Get("endpoint_host"))
+	assert.Equal(t, "51820", peers[0].Get("endpoint_port"))
+	assert.Equal(t, "1", peers[0].Get("route_allowed_ips"))
+	assert.NotNil(t, network.Section("lan"))
+
+	assert.Error(t, ExportUCI(network, "wg-1", nil, false))
+	assert.Error(t, ExportUCI(network, "lan", wgConf, false))
+	assert.Equal(t, "static", network.Section("lan").Get("proto"))
+
+	// nothing is changed on error
+	before := string(network.Marshal())
+	assert.Error(t, ExportUCI(network, "wg0", []byte("[Peer]\nEndpoint = bad\n"), false))
+	assert.Equal(t, before, string(network.Marshal()))
+}
@@ -0,0 +1,193 @@
+// Package probe checks reachability of WireGuard peers over the tunnel, by ICMP echo, TCP connect or UDP echo
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ICMP = "icmp"
+	TCP  = "tcp"
+	UDP  = "udp"
+)
+
+// payload is sent by ICMP and UDP probes, UDP echo servers send it back
+var payload = []byte("wg-quick-op probe")
+
+// Probe is a probe to an address in the tunnel, like icmp:10.0.0.1, tcp:[fd00::1]:179 or udp:10.0.0.1:7
+type Probe struct {
+	Proto string
+	// Addr is IP for ICMP, or IP:port for TCP and UDP
+	Addr string
+}
+
+// Parse parses probe in the form of proto:addr
+func Parse(s string) (*Probe, error) {
+	proto, addr, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid probe %q, expected icmp:<ip>, tcp:<ip>:<port> or udp:<ip>:<port>", s)
+	}
+	p := &Probe{Proto: strings.ToLower(proto), Addr: addr}
+	switch p.Proto {
+	case ICMP:
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid probe %q, %s is not an IP", s, addr)
+		}
+	case TCP, UDP:
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe %q: %w", s, err)
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid probe %q, %s is not an IP", s, host)
+		}
+		if _, err := net.LookupPort(p.Proto, port); err != nil {
+			return nil, fmt.Errorf("invalid probe %q: %w", s, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid probe %q, unknown protocol %s", s, proto)
+	}
+	return p, nil
+}
+
+func (p *Probe) String() string {
+	return p.Proto + ":" + p.Addr
+}
+
+// Run probes through iface (SO_BINDTODEVICE) until ctx is done, returning the round-trip time.
+// Empty iface probes through the routing table
+func (p *Probe) Run(ctx context.Context, iface string) (time.Duration, error) {
+	switch p.Proto {
+	case ICMP:
+		return p.icmp(ctx, iface)
+	case TCP:
+		return p.tcp(ctx, iface)
+	case UDP:
+		return p.udp(ctx, iface)
+	}
+	return 0, fmt.Errorf("unknown protocol %s", p.Proto)
+}
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		if iface == "" {
+			return nil
+		}
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = unix.BindToDevice(int(fd), iface)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+func (p *Probe) icmp(ctx context.Context, iface string) (time.Duration, error) {
+	ip := net.ParseIP(p.Addr)
+	network, proto := "ip4:icmp", 1
+	var typ, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, proto = "ip6:ipv6-icmp", 58
+		typ, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	lc := net.ListenConfig{Control: bindToDevice(iface)}
+	conn, err := lc.ListenPacket(ctx, network, "")
+	if err != nil {
+		return 0, fmt.Errorf("listen %s failed: %w", network, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	echo := &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: rand.IntN(0xffff), Data: payload}
+	b, err := (&icmp.Message{Type: typ, Body: echo}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		return 0, fmt.Errorf("send echo failed: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, fmt.Errorf("no echo reply: %w", ctx.Err())
+			}
+			return 0, fmt.Errorf("receive echo reply failed: %w", err)
+		}
+		// raw sockets receive all ICMP messages, match ours
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || msg.Type != reply {
+			continue
+		}
+		body, ok := msg.Body.(*icmp.Echo)
+		if !ok || body.ID != echo.ID || body.Seq != echo.Seq || !from.(*net.IPAddr).IP.Equal(ip) {
+			continue
+		}
+		return time.Since(start), nil
+	}
+}
+
+func (p *Probe) tcp(ctx context.Context, iface string) (time.Duration, error) {
+	d := net.Dialer{Control: bindToDevice(iface)}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	rtt := time.Since(start)
+	// RST also comes from the peer
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return rtt, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	_ = conn.Close()
+	return rtt, nil
+}
+
+func (p *Probe) udp(ctx context.Context, iface string) (time.Duration, error) {
+	d := net.Dialer{Control: bindToDevice(iface)}
+	conn, err := d.DialContext(ctx, "udp", p.Addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, fmt.Errorf("send failed: %w", err)
+	}
+	buf := make([]byte, len(payload)+1)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, fmt.Errorf("no echo: %w", ctx.Err())
+			}
+			return 0, fmt.Errorf("receive echo failed: %w", err)
+		}
+		if bytes.Equal(buf[:n], payload) {
+			return time.Since(start), nil
+		}
+	}
+}
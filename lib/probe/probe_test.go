@@ -0,0 +1,67 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Probe{
+		"icmp:10.0.0.1":     {ICMP, "10.0.0.1"},
+		"ICMP:fe80::1":      {ICMP, "fe80::1"},
+		"tcp:[fd00::1]:179": {TCP, "[fd00::1]:179"},
+		"udp:10.0.0.1:7":    {UDP, "10.0.0.1:7"},
+	} {
+		p, err := Parse(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, *p, s)
+	}
+	for _, s := range []string{"", "10.0.0.1", "icmp:peer.example.com", "tcp:10.0.0.1", "udp:host:7", "http:10.0.0.1:80"} {
+		_, err := Parse(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	_, err = (&Probe{TCP, ln.Addr().String()}).Run(ctx, "")
+	assert.NoError(t, err)
+	ln.Close()
+	// refused by the peer is reachable
+	_, err = (&Probe{TCP, ln.Addr().String()}).Run(ctx, "")
+	assert.NoError(t, err)
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	_, err = (&Probe{UDP, echo.LocalAddr().String()}).Run(ctx, "")
+	assert.NoError(t, err)
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = (&Probe{UDP, silent.LocalAddr().String()}).Run(short, "")
+	assert.Error(t, err)
+}
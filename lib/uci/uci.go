@@ -0,0 +1,404 @@
+// Package uci reads and writes OpenWrt UCI config files, without depending on the uci binary
+package uci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Config is a UCI package. Comments, blank lines and lines not understood like package are kept verbatim with
+// the section or option following them, so they survive rewriting the file
+type Config struct {
+	Sections []*Section
+
+	// trailer are lines after the last option
+	trailer []string
+}
+
+type Section struct {
+	Type    string
+	Name    string
+	Options []*Option
+
+	// leading are lines before the config line, comment is the comment at its end
+	leading []string
+	comment string
+}
+
+type Option struct {
+	Name   string
+	Values []string
+	List   bool
+
+	// leading are lines before the first value, comments are the comments at the end of each value
+	leading  []string
+	comments []string
+}
+
+// Load reads package pkg from dir, a missing file is treated as an empty config
+func Load(dir, pkg string) (*Config, error) {
+	b, err := os.ReadFile(filepath.Join(dir, pkg))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Save writes package pkg to dir atomically
+func Save(dir, pkg string, c *Config) error {
+	path := filepath.Join(dir, pkg)
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+	tmp := filepath.Join(dir, "."+pkg+".tmp")
+	if err := os.WriteFile(tmp, c.Marshal(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Edit loads package pkg from dir, and saves it if edit reports a change. The file is locked exclusively
+// from load to save, so concurrent editors, like up of multiple interfaces, don't lose each other's changes
+func Edit(dir, pkg string, edit func(c *Config) (bool, error)) error {
+	f, err := lockFile(filepath.Join(dir, pkg))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	changed, err := edit(c)
+	if err != nil || !changed {
+		return err
+	}
+	return Save(dir, pkg, c)
+}
+
+// lockFile opens and locks path, created if missing. Save replaces the file by rename, so the lock is
+// taken again if the file is replaced while waiting for it
+func lockFile(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s failed: %w", path, err)
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// Parse parses UCI config in the format of /etc/config/*
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	var (
+		section *Section
+		// kept are comments and unknown lines waiting for the section or option following them
+		kept []string
+	)
+	for no, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		words, comment, err := splitWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("[line %d]: %w", no+1, err)
+		}
+		if len(words) == 0 {
+			kept = append(kept, line)
+			continue
+		}
+		switch words[0] {
+		case "config":
+			if len(words) < 2 || len(words) > 3 {
+				return nil, fmt.Errorf("[line %d]: config requires a type and an optional name", no+1)
+			}
+			section = &Section{Type: words[1], leading: kept, comment: comment}
+			kept = nil
+			if len(words) == 3 {
+				section.Name = words[2]
+			}
+			c.Sections = append(c.Sections, section)
+		case "option", "list":
+			if section == nil {
+				return nil, fmt.Errorf("[line %d]: %s outside of config section", no+1, words[0])
+			}
+			if len(words) != 3 {
+				return nil, fmt.Errorf("[line %d]: %s requires a name and a value", no+1, words[0])
+			}
+			if words[0] == "option" {
+				section.Set(words[1], words[2])
+			} else {
+				section.AddList(words[1], words[2])
+			}
+			opt := section.option(words[1])
+			if opt.leading == nil {
+				opt.leading = kept
+			} else {
+				opt.leading = append(opt.leading, kept...)
+			}
+			kept = nil
+			if comment != "" {
+				opt.comments = append(opt.comments, make([]string, len(opt.Values)-len(opt.comments))...)
+				opt.comments[len(opt.Values)-1] = comment
+			}
+		default:
+			kept = append(kept, line)
+		}
+	}
+	c.trailer = kept
+	return c, nil
+}
+
+// splitWords splits a line like shell, supports single, double quotes and backslash escapes.
+// The comment at the end of the line is returned as is
+func splitWords(line string) ([]string, string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for i, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '#':
+			if inWord {
+				word.WriteRune(r)
+				continue
+			}
+			return words, line[i:], nil
+		case r == ' ' || r == '\t' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, "", errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, "", nil
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Marshal formats the config like `uci export`, with comments and unknown lines kept
+func (c *Config) Marshal() []byte {
+	b := &bytes.Buffer{}
+	writeLines := func(lines []string) {
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	writeComment := func(comment string) {
+		if comment != "" {
+			b.WriteString(" " + comment)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, section := range c.Sections {
+		// sections added are separated by a blank line like `uci export`
+		if section.leading == nil && b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		writeLines(section.leading)
+		b.WriteString("config " + section.Type)
+		if section.Name != "" {
+			b.WriteString(" " + quote(section.Name))
+		}
+		writeComment(section.comment)
+		for _, opt := range section.Options {
+			writeLines(opt.leading)
+			for i, v := range opt.Values {
+				if opt.List {
+					b.WriteString("\tlist " + opt.Name + " " + quote(v))
+				} else {
+					b.WriteString("\toption " + opt.Name + " " + quote(v))
+				}
+				var comment string
+				if i < len(opt.comments) {
+					comment = opt.comments[i]
+				}
+				writeComment(comment)
+			}
+		}
+	}
+	writeLines(c.trailer)
+	return b.Bytes()
+}
+
+// Section returns the named section, nil if not existed
+func (c *Config) Section(name string) *Section {
+	for _, s := range c.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// SectionsByType returns all sections of typ in order
+func (c *Config) SectionsByType(typ string) []*Section {
+	var sections []*Section
+	for _, s := range c.Sections {
+		if s.Type == typ {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// Add returns the named section of typ, it is created if not existed, or re-typed if typ mismatches
+func (c *Config) Add(typ, name string) *Section {
+	if s := c.Section(name); s != nil && name != "" {
+		s.Type = typ
+		return s
+	}
+	s := &Section{Type: typ, Name: name}
+	c.Sections = append(c.Sections, s)
+	return s
+}
+
+// Delete removes sections matching f
+func (c *Config) Delete(f func(s *Section) bool) {
+	c.Sections = slices.DeleteFunc(c.Sections, f)
+}
+
+func (s *Section) option(name string) *Option {
+	for _, opt := range s.Options {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// Get returns value of an option, the last value of a list
+func (s *Section) Get(name string) string {
+	opt := s.option(name)
+	if opt == nil || len(opt.Values) == 0 {
+		return ""
+	}
+	return opt.Values[len(opt.Values)-1]
+}
+
+// GetList returns values of a list, or the value of an option
+func (s *Section) GetList(name string) []string {
+	opt := s.option(name)
+	if opt == nil {
+		return nil
+	}
+	return opt.Values
+}
+
+// Set sets an option, replacing any option or list with the same name
+func (s *Section) Set(name, value string) {
+	if opt := s.option(name); opt != nil {
+		opt.Values, opt.List, opt.comments = []string{value}, false, nil
+		return
+	}
+	s.Options = append(s.Options, &Option{Name: name, Values: []string{value}})
+}
+
+// SetList replaces the list, an empty list removes it
+func (s *Section) SetList(name string, values []string) {
+	if len(values) == 0 {
+		s.Unset(name)
+		return
+	}
+	if opt := s.option(name); opt != nil {
+		opt.Values, opt.List, opt.comments = slices.Clone(values), true, nil
+		return
+	}
+	s.Options = append(s.Options, &Option{Name: name, Values: slices.Clone(values), List: true})
+}
+
+// AddList appends value to the list
+func (s *Section) AddList(name, value string) {
+	if opt := s.option(name); opt != nil {
+		opt.Values, opt.List = append(opt.Values, value), true
+		return
+	}
+	s.Options = append(s.Options, &Option{Name: name, Values: []string{value}, List: true})
+}
+
+// DelList removes all value from the list, the list is removed if it becomes empty
+func (s *Section) DelList(name, value string) {
+	opt := s.option(name)
+	if opt == nil {
+		return
+	}
+	for i := len(opt.Values) - 1; i >= 0; i-- {
+		if opt.Values[i] != value {
+			continue
+		}
+		opt.Values = slices.Delete(opt.Values, i, i+1)
+		if i < len(opt.comments) {
+			opt.comments = slices.Delete(opt.comments, i, i+1)
+		}
+	}
+	if len(opt.Values) == 0 {
+		s.Unset(name)
+	}
+}
+
+// Unset removes an option or list
+func (s *Section) Unset(name string) {
+	s.Options = slices.DeleteFunc(s.Options, func(opt *Option) bool { return opt.Name == name })
+}
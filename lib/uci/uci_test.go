@@ -0,0 +1,133 @@
+package uci
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testNetwork = `
+config interface 'loopback'
+	option device 'lo'
+	option proto 'static'
+	option ipaddr '127.0.0.1'
+
+config interface 'wg0'  # comment
+	option proto "wireguard"
+	option private_key 'yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk='
+	list addresses '10.0.0.1/24'
+	list addresses '10.0.1.1/24'
+
+config wireguard_wg0
+	option description 'it'\''s a peer'
+	option route_allowed_ips 1
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testNetwork))
+	require.NoError(t, err)
+	require.Len(t, c.Sections, 3)
+
+	wg0 := c.Section("wg0")
+	require.NotNil(t, wg0)
+	assert.Equal(t, "interface", wg0.Type)
+	assert.Equal(t, "wireguard", wg0.Get("proto"))
+	assert.Equal(t, []string{"10.0.0.1/24", "10.0.1.1/24"}, wg0.GetList("addresses"))
+
+	peers := c.SectionsByType("wireguard_wg0")
+	require.Len(t, peers, 1)
+	assert.Equal(t, "it's a peer", peers[0].Get("description"))
+	assert.Equal(t, "1", peers[0].Get("route_allowed_ips"))
+
+	again, err := Parse(c.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, c, again)
+}
+
+func TestRoundTripComments(t *testing.T) {
+	const commented = `package network
+# managed by hand
+
+# loopback, don't touch
+config interface 'loopback' # lo
+	option device 'lo'
+	# static only
+	option proto 'static'
+	list dns '1.1.1.1' # primary
+	list dns '8.8.8.8'
+	unknown line kept
+
+config interface 'lan'
+	option proto 'dhcp'
+
+# end of file
+`
+	c, err := Parse([]byte(commented))
+	require.NoError(t, err)
+	assert.Equal(t, commented, string(c.Marshal()))
+
+	// edits keep comments of untouched options and sections
+	c.Section("loopback").DelList("dns", "1.1.1.1")
+	c.Section("lan").Set("proto", "static")
+	c.Add("interface", "wg0").Set("proto", "none")
+	assert.Equal(t, `package network
+# managed by hand
+
+# loopback, don't touch
+config interface 'loopback' # lo
+	option device 'lo'
+	# static only
+	option proto 'static'
+	list dns '8.8.8.8'
+	unknown line kept
+
+config interface 'lan'
+	option proto 'static'
+
+config interface 'wg0'
+	option proto 'none'
+
+# end of file
+`, string(c.Marshal()))
+}
+
+func TestEdit(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Load(dir, "network")
+	require.NoError(t, err)
+
+	s := c.Add("interface", "tun00")
+	s.Set("proto", "none")
+	s.AddList("network", "a")
+	s.AddList("network", "b")
+	s.DelList("network", "a")
+	require.NoError(t, Save(dir, "network", c))
+
+	c, err = Load(dir, "network")
+	require.NoError(t, err)
+	assert.Equal(t, "config interface 'tun00'\n\toption proto 'none'\n\tlist network 'b'\n", string(c.Marshal()))
+
+	c.Delete(func(s *Section) bool { return s.Name == "tun00" })
+	assert.Empty(t, c.Sections)
+}
+
+func TestEditConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			assert.NoError(t, Edit(dir, "firewall", func(c *Config) (bool, error) {
+				c.Add("zone", fmt.Sprintf("z%d", i))
+				return true, nil
+			}))
+		})
+	}
+	wg.Wait()
+
+	c, err := Load(dir, "firewall")
+	require.NoError(t, err)
+	assert.Len(t, c.SectionsByType("zone"), 20)
+}
@@ -0,0 +1,70 @@
+// Package wgconf tokenizes wg-quick configs into sections and directives, leaving values uninterpreted,
+// so the full parser, extension directives and UCI export share the same syntax
+package wgconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	Interface = "Interface"
+	Peer      = "Peer"
+)
+
+// Directive is a `Key = Value` line
+type Directive struct {
+	// Line is the line number, starting from 1
+	Line  int
+	Key   string
+	Value string
+}
+
+// Section is an [Interface] or [Peer] section with its directives in order
+type Section struct {
+	Name       string
+	Line       int
+	Directives []Directive
+}
+
+// Get returns value of the last directive of key, or empty
+func (s *Section) Get(key string) string {
+	var value string
+	for _, d := range s.Directives {
+		if d.Key == key {
+			value = d.Value
+		}
+	}
+	return value
+}
+
+// Parse splits text into sections. As wg-quick, anything after # in a line is a comment
+func Parse(text []byte) ([]Section, error) {
+	var sections []Section
+	for no, line := range strings.Split(string(text), "\n") {
+		no++
+		line, _, _ = strings.Cut(line, "#")
+		ln := strings.TrimSpace(line)
+		if len(ln) == 0 {
+			continue
+		}
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			name := strings.TrimSpace(ln[1 : len(ln)-1])
+			if name != Interface && name != Peer {
+				return nil, fmt.Errorf("[line %d] unknown section %s", no, ln)
+			}
+			sections = append(sections, Section{Name: name, Line: no})
+			continue
+		}
+		lhs, rhs, found := strings.Cut(ln, "=")
+		if !found {
+			return nil, fmt.Errorf("[line %d] cannot parse, missing =", no)
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("[line %d] cannot parse, directive outside of any section", no)
+		}
+		s := &sections[len(sections)-1]
+		s.Directives = append(s.Directives, Directive{Line: no, Key: strings.TrimSpace(lhs), Value: strings.TrimSpace(rhs)})
+	}
+	return sections, nil
+}
@@ -0,0 +1,39 @@
+package wgconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	sections, err := Parse([]byte(`# comment
+[Interface]
+PrivateKey = a2V5PQ== # trailing comment
+PostUp = echo a=b
+
+[Peer]
+PublicKey=cGVlcg==
+[Peer]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []Section{
+		{Name: Interface, Line: 2, Directives: []Directive{
+			{Line: 3, Key: "PrivateKey", Value: "a2V5PQ=="},
+			{Line: 4, Key: "PostUp", Value: "echo a=b"},
+		}},
+		{Name: Peer, Line: 6, Directives: []Directive{{Line: 7, Key: "PublicKey", Value: "cGVlcg=="}}},
+		{Name: Peer, Line: 8},
+	}, sections)
+	assert.Equal(t, "cGVlcg==", sections[1].Get("PublicKey"))
+
+	for _, text := range []string{
+		"PrivateKey = a2V5PQ==",
+		"[Interface]\nPrivateKey",
+		"[Wireguard]\n",
+	} {
+		_, err := Parse([]byte(text))
+		assert.Error(t, err, text)
+	}
+}
@@ -16,6 +16,8 @@ import (
 
 	"github.com/dn-11/wg-quick-op/conf"
 	"github.com/dn-11/wg-quick-op/lib/dns"
+	"github.com/dn-11/wg-quick-op/lib/probe"
+	"github.com/dn-11/wg-quick-op/lib/wgconf"
 	"github.com/rs/zerolog/log"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -27,6 +29,9 @@ type Config struct {
 	// Address list of IP (v4 or v6) addresses (optionally with CIDR masks) to be assigned to the interface. May be specified multiple times.
 	Address []net.IPNet
 
+	// LinkLocal is an IPv6 link-local address assigned to the interface, or auto to derive it from the public key by LinkLocalFromKey
+	LinkLocal string
+
 	// list of IP (v4 or v6) addresses to be set as the interface’s DNS servers. May be specified multiple times. Upon bringing the interface up, this runs ‘resolvconf -a tun.INTERFACE -m 0 -x‘ and upon bringing it down, this runs ‘resolvconf -d tun.INTERFACE‘. If these particular invocations of resolvconf(8) are undesirable, the PostUp and PostDown keys below may be used instead.
 	DNS []net.IP
 
@@ -53,6 +58,9 @@ type Config struct {
 
 	// WireGuard-go binary path, left empty for kernel WireGuard
 	WgBin string
+
+	// SaveConfig is accepted for compatibility with wg-quick, the config is never saved back
+	SaveConfig bool
 }
 
 type ParseMode int
@@ -88,9 +96,21 @@ func toSeconds(duration time.Duration) int {
 	return int(duration / time.Second)
 }
 
+// serializeTable returns Table directive, empty for the default
+func serializeTable(table *int) string {
+	if table == nil {
+		return "off"
+	}
+	if *table == 0 {
+		return ""
+	}
+	return strconv.Itoa(*table)
+}
+
 var funcMap = template.FuncMap(map[string]interface{}{
 	"wgKey":     serializeKey,
 	"toSeconds": toSeconds,
+	"table":     serializeTable,
 })
 
 var cfgTemplate = template.Must(
@@ -111,17 +131,27 @@ const wgtypeTemplateSpec = `[Interface]
 {{- range .Address }}
 Address = {{ . }}
 {{- end }}
+{{- if .LinkLocal }}{{ "\n" }}LinkLocal = {{ .LinkLocal }}{{ end }}
 {{- range .DNS }}
 DNS = {{ . }}
 {{- end }}
 PrivateKey = {{ .PrivateKey | wgKey }}
 {{- if .ListenPort }}{{ "\n" }}ListenPort = {{ .ListenPort }}{{ end }}
 {{- if .MTU }}{{ "\n" }}MTU = {{ .MTU }}{{ end }}
-{{- if .Table }}{{ "\n" }}Table = {{ .Table }}{{ end }}
-{{- if .PreUp }}{{ "\n" }}PreUp = {{ .PreUp }}{{ end }}
-{{- if .PostUp }}{{ "\n" }}PostUp = {{ .PostUp }}{{ end }}
-{{- if .PreDown }}{{ "\n" }}PreDown = {{ .PreDown }}{{ end }}
-{{- if .PostDown }}{{ "\n" }}PostDown = {{ .PostDown }}{{ end }}
+{{- with table .Table }}{{ "\n" }}Table = {{ . }}{{ end }}
+{{- range .PreUp }}
+PreUp = {{ . }}
+{{- end }}
+{{- range .PostUp }}
+PostUp = {{ . }}
+{{- end }}
+{{- range .PreDown }}
+PreDown = {{ . }}
+{{- end }}
+{{- range .PostDown }}
+PostDown = {{ . }}
+{{- end }}
+{{- if .SaveConfig }}{{ "\n" }}SaveConfig = true{{ end }}
 {{- range .Peers }}
 {{- "\n" }}
 [Peer]
@@ -144,90 +174,38 @@ func ParseKey(key string) (wgtypes.Key, error) {
 	return pkey, nil
 }
 
-type parseState int
-
-const (
-	unknown parseState = iota
-	inter              = iota
-	peer               = iota
-)
-
 func (cfg *Config) UnmarshalText(text []byte) error {
-	*cfg = *newConfig() // Zero out the config
-	state := unknown
-	var peerCfg *wgtypes.PeerConfig
-	for no, line := range strings.Split(string(text), "\n") {
-		line, _, _ = strings.Cut(line, "#")
-		ln := strings.TrimSpace(line)
-		if len(ln) == 0 {
-			continue
-		}
-		switch ln {
-		case "[Interface]":
-			state = inter
-		case "[Peer]":
-			state = peer
-			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{})
-			peerCfg = &cfg.Peers[len(cfg.Peers)-1]
-		default:
-			parts := strings.Split(ln, "=")
-			if len(parts) < 2 {
-				return fmt.Errorf("cannot parse line %d, missing =", no)
-			}
-			lhs := strings.TrimSpace(parts[0])
-			rhs := strings.TrimSpace(strings.Join(parts[1:], "="))
-
-			switch state {
-			case inter:
-				if err := parseInterfaceLine(cfg, lhs, rhs); err != nil {
-					return fmt.Errorf("[line %d]: %v", no+1, err)
-				}
-			case peer:
-				if err := parsePeerLine(peerCfg, lhs, rhs); err != nil {
-					return fmt.Errorf("[line %d]: %v", no+1, err)
-				}
-			default:
-				return fmt.Errorf("[line %d] cannot parse, unknown state", no+1)
-			}
-		}
-	}
-	return nil
+	return cfg.unmarshal(text, true)
 }
 
 func (cfg *Config) UnmarshalTextNoPeer(text []byte) error {
-	*cfg = *newConfig() // Zero out the config
-	state := unknown
-	for no, line := range strings.Split(string(text), "\n") {
-		line, _, _ = strings.Cut(line, "#")
-		ln := strings.TrimSpace(line)
-		if len(ln) == 0 {
-			continue
-		}
-		switch ln {
-		case "[Interface]":
-			state = inter
-		case "[Peer]":
-			state = peer
-			continue
-			//skip
-		default:
-			parts := strings.Split(ln, "=")
-			if len(parts) < 2 {
-				return fmt.Errorf("cannot parse line %d, missing =", no)
-			}
-			lhs := strings.TrimSpace(parts[0])
-			rhs := strings.TrimSpace(strings.Join(parts[1:], "="))
+	return cfg.unmarshal(text, false)
+}
 
-			switch state {
-			case inter:
-				if err := parseInterfaceLine(cfg, lhs, rhs); err != nil {
-					return fmt.Errorf("[line %d]: %v", no+1, err)
+func (cfg *Config) unmarshal(text []byte, withPeers bool) error {
+	*cfg = *newConfig() // Zero out the config
+	sections, err := wgconf.Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, section := range sections {
+		switch section.Name {
+		case wgconf.Interface:
+			for _, d := range section.Directives {
+				if err := parseInterfaceLine(cfg, d.Key, d.Value); err != nil {
+					return fmt.Errorf("[line %d]: %v", d.Line, err)
 				}
-			case peer:
-				// skip
+			}
+		case wgconf.Peer:
+			if !withPeers {
 				continue
-			default:
-				return fmt.Errorf("[line %d] cannot parse, unknown state", no+1)
+			}
+			cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{})
+			peerCfg := &cfg.Peers[len(cfg.Peers)-1]
+			for _, d := range section.Directives {
+				if err := parsePeerLine(peerCfg, d.Key, d.Value); err != nil {
+					return fmt.Errorf("[line %d]: %v", d.Line, err)
+				}
 			}
 		}
 	}
@@ -300,61 +278,53 @@ func GetConfig(name string) (*Config, error) {
 }
 
 func GetUnresolvedEndpoints(name string) (map[wgtypes.Key]string, error) {
-	b, err := os.ReadFile(filepath.Join("/etc/wireguard/" + name + ".conf"))
+	options, err := GetPeerOptions(name)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read file:%v", err)
+		return nil, err
 	}
-	state := unknown
-	var endpoint string
-	var pubkey string
 	unresolvedEndpoints := make(map[wgtypes.Key]string)
-	for no, line := range strings.Split(string(b), "\n") {
-		ln := strings.TrimSpace(line)
-		if len(ln) == 0 || ln[0] == '#' {
-			continue
-		}
-		switch ln {
-		case "[Interface]":
-			state = inter
-			continue
-		case "[Peer]":
-			state = peer
-			pubkey = ""
-			endpoint = ""
-			continue
-		}
-
-		if state != peer {
-			continue
+	for key, opts := range options {
+		if endpoint := opts["Endpoint"]; endpoint != "" {
+			unresolvedEndpoints[key] = endpoint
+		} else if endpoint := opts["EndpointSRV"]; endpoint != "" {
+			unresolvedEndpoints[key] = endpoint
 		}
+	}
+	return unresolvedEndpoints, nil
+}
 
-		parts := strings.Split(ln, "=")
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("cannot parse line %d, missing =", no)
-		}
-		lhs := strings.TrimSpace(parts[0])
-		rhs := strings.TrimSpace(strings.Join(parts[1:], "="))
-
-		switch lhs {
-		case "PublicKey":
-			pubkey = rhs
-		case "Endpoint":
-			endpoint = rhs
-		}
+// GetPeerOptions returns directives of each peer as written in config, keyed by public key,
+// so extension directives like Probe are read without resolving anything
+func GetPeerOptions(name string) (map[wgtypes.Key]map[string]string, error) {
+	b, err := os.ReadFile(filepath.Join("/etc/wireguard/" + name + ".conf"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file:%v", err)
+	}
+	return parsePeerOptions(b)
+}
 
-		if pubkey == "" || endpoint == "" {
+func parsePeerOptions(text []byte) (map[wgtypes.Key]map[string]string, error) {
+	sections, err := wgconf.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	options := make(map[wgtypes.Key]map[string]string)
+	for _, section := range sections {
+		// peers without public key are invalid anyway, leave them to the full parser
+		if section.Name != wgconf.Peer || section.Get("PublicKey") == "" {
 			continue
 		}
-
-		key, err := wgtypes.ParseKey(pubkey)
+		key, err := wgtypes.ParseKey(section.Get("PublicKey"))
 		if err != nil {
-			return nil, fmt.Errorf("cannot parse key:%v", err)
+			return nil, fmt.Errorf("[line %d]: cannot parse key:%v", section.Line, err)
 		}
-		unresolvedEndpoints[key] = endpoint
-		pubkey = ""
-		endpoint = ""
+		opts := make(map[string]string)
+		for _, d := range section.Directives {
+			opts[d.Key] = d.Value
+		}
+		options[key] = opts
 	}
-	return unresolvedEndpoints, nil
+	return options, nil
 }
 
 func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
@@ -367,6 +337,19 @@ func parseInterfaceLine(cfg *Config, lhs string, rhs string) error {
 			}
 			cfg.Address = append(cfg.Address, net.IPNet{IP: ip, Mask: cidr.Mask})
 		}
+	case "LinkLocal":
+		if rhs != "auto" {
+			if _, err := parseLinkLocal(rhs); err != nil {
+				return err
+			}
+		}
+		cfg.LinkLocal = rhs
+	case "SaveConfig":
+		save, err := strconv.ParseBool(rhs)
+		if err != nil {
+			return err
+		}
+		cfg.SaveConfig = save
 	case "DNS":
 		for _, addr := range strings.Split(rhs, ",") {
 			ip := net.ParseIP(strings.TrimSpace(addr))
@@ -454,12 +437,32 @@ func parsePeerLine(peerCfg *wgtypes.PeerConfig, lhs string, rhs string) error {
 			peerCfg.AllowedIPs = append(peerCfg.AllowedIPs, net.IPNet{IP: ip, Mask: cidr.Mask})
 		}
 	case "Endpoint":
-		addr, err := dns.ResolveUDPAddr("", rhs)
+		addr, err := dns.ResolveEndpoint(rhs)
 		if err != nil {
 			log.Warn().Err(err).Msg("resolve endpoint")
 			return nil
 		}
 		peerCfg.Endpoint = addr
+	case "EndpointSRV":
+		if !dns.IsSRVName(rhs) {
+			return fmt.Errorf("%s is not a SRV name like _wireguard._udp.example.com", rhs)
+		}
+		addr, err := dns.ResolveSRV(rhs)
+		if err != nil {
+			log.Warn().Err(err).Msg("resolve endpoint SRV")
+			return nil
+		}
+		peerCfg.Endpoint = addr
+	case "ASN":
+		// read by `bird gen` through GetPeerOptions
+		if _, err := strconv.ParseUint(rhs, 10, 32); err != nil {
+			return fmt.Errorf("invalid ASN %s: %v", rhs, err)
+		}
+	case "Probe":
+		// read by the service through GetPeerOptions
+		if _, err := probe.Parse(rhs); err != nil {
+			return err
+		}
 	case "PersistentKeepalive":
 		t, err := strconv.ParseInt(rhs, 10, 64)
 		if err != nil {
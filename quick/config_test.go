@@ -1,6 +1,7 @@
 package quick
 
 import (
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,6 +50,15 @@ PreDown = ip rule delete ipproto tcp dport 22 table 1234
 PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
 AllowedIPs = 0.0.0.0/0
 PersistentKeepalive = 25
+`,
+	"link-local": `[Interface]
+Address = 172.16.0.1/32
+LinkLocal = auto
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 0.0.0.0/0, fe80::/64
 `,
 }
 
@@ -65,3 +75,82 @@ func TestExampleConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshalInterface(t *testing.T) {
+	c := &Config{}
+	assert.NoError(t, c.UnmarshalText([]byte(`[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+`)))
+	b, err := c.MarshalText()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "Table", "default table is not written")
+
+	text := `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Table = off
+PreUp = echo pre
+PostUp = sysctl -w net.ipv6.conf.%i.forwarding=1
+PostUp = echo up
+PreDown = echo down
+PostDown = echo post
+SaveConfig = true
+`
+	assert.NoError(t, c.UnmarshalText([]byte(text)))
+	assert.Nil(t, c.Table)
+	assert.Equal(t, []string{"sysctl -w net.ipv6.conf.%i.forwarding=1", "echo up"}, c.PostUp)
+	assert.True(t, c.SaveConfig)
+	b, err = c.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, text, string(b))
+
+	assert.Error(t, c.UnmarshalText([]byte("[Interface]\nSaveConfig = maybe\n")))
+}
+
+func TestLinkLocal(t *testing.T) {
+	key, _ := ParseKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	addr := LinkLocalFromKey(key)
+	assert.True(t, addr.IP.IsLinkLocalUnicast())
+	assert.Equal(t, "fe80::/64", (&net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}).String())
+	assert.Equal(t, addr, LinkLocalFromKey(key))
+
+	c := &Config{}
+	assert.NoError(t, c.UnmarshalText([]byte(testConfigs["link-local"])))
+	got, err := c.LinkLocalAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, LinkLocalFromKey(c.PrivateKey.PublicKey()), *got)
+
+	c.LinkLocal = "fe80::1"
+	got, err = c.LinkLocalAddress()
+	assert.NoError(t, err)
+	assert.Equal(t, "fe80::1/64", got.String())
+
+	for _, bad := range []string{"fd00::1/64", "169.254.0.1/16", "fe80::zz"} {
+		assert.Error(t, c.UnmarshalText([]byte("[Interface]\nLinkLocal = "+bad+"\n")), bad)
+	}
+}
+
+func TestPeerOptions(t *testing.T) {
+	options, err := parsePeerOptions([]byte(`[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = peer.example.dn11:51820 # comment
+Probe = tcp:[fd00::1]:179
+
+[Peer]
+PublicKey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.192.122.4/32
+`))
+	assert.NoError(t, err)
+	assert.Len(t, options, 2)
+	key, _ := ParseKey("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=")
+	assert.Equal(t, "peer.example.dn11:51820", options[key]["Endpoint"])
+	assert.Equal(t, "tcp:[fd00::1]:179", options[key]["Probe"])
+
+	options, err = parsePeerOptions([]byte("[Peer]\nEndpoint = 1.1.1.1:1\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, options)
+	_, err = parsePeerOptions([]byte("[Peer]\nPublicKey = bad\n"))
+	assert.Error(t, err)
+}
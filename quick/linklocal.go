@@ -0,0 +1,55 @@
+package quick
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// LinkLocalFromKey derives a stable fe80::/64 address from the public key, so peers with LinkLocal = auto
+// know the address of each other
+func LinkLocalFromKey(key wgtypes.Key) net.IPNet {
+	sum := sha256.Sum256(key[:])
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	copy(ip[8:], sum[:8])
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}
+}
+
+// parseLinkLocal parses link-local address with optional prefix length, /64 by default
+func parseLinkLocal(s string) (net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		s += "/64"
+	}
+	ip, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	if ip.To4() != nil || !ip.IsLinkLocalUnicast() {
+		return net.IPNet{}, fmt.Errorf("%s is not an IPv6 link-local address", s)
+	}
+	return net.IPNet{IP: ip, Mask: cidr.Mask}, nil
+}
+
+// LinkLocalAddress returns the address set by LinkLocal, or nil if not set
+func (cfg *Config) LinkLocalAddress() (*net.IPNet, error) {
+	switch cfg.LinkLocal {
+	case "":
+		return nil, nil
+	case "auto":
+		if cfg.PrivateKey == nil {
+			return nil, errors.New("PrivateKey is required by LinkLocal = auto")
+		}
+		addr := LinkLocalFromKey(cfg.PrivateKey.PublicKey())
+		return &addr, nil
+	}
+	addr, err := parseLinkLocal(cfg.LinkLocal)
+	if err != nil {
+		return nil, err
+	}
+	return &addr, nil
+}
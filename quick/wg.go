@@ -7,9 +7,11 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"syscall"
 
+	"github.com/dn-11/wg-quick-op/lib/openwrt"
 	"github.com/rs/zerolog"
 
 	"github.com/vishvananda/netlink"
@@ -55,6 +57,10 @@ func Up(cfg *Config, iface string, logger zerolog.Logger) error {
 		logger.Info().Msg("applied post-up command")
 	}
 
+	if err := openwrt.Attach(iface); err != nil {
+		logger.Err(err).Msg("cannot attach UCI interface")
+	}
+
 	return nil
 }
 
@@ -85,6 +91,10 @@ func Down(cfg *Config, iface string, logger zerolog.Logger) error {
 	}
 	logger.Info().Msg("link deleted")
 
+	if err := openwrt.Detach(iface); err != nil {
+		logger.Err(err).Msg("cannot detach UCI interface")
+	}
+
 	if len(cfg.PostDown) > 0 {
 		for _, cmd := range cfg.PostDown {
 			if err := execSh(cmd, iface, logger); err != nil {
@@ -226,8 +236,14 @@ func SyncLink(cfg *Config, iface string, logger zerolog.Logger) (netlink.Link, e
 	return link, nil
 }
 
-// SyncAddress adds/deletes all lind assigned IPV4 addressed as specified in the config
+// SyncAddress adds/deletes all lind assigned IPV4 addressed as specified in the config, and the LinkLocal address
 func SyncAddress(cfg *Config, link netlink.Link, logger zerolog.Logger) error {
+	linkLocal, err := cfg.LinkLocalAddress()
+	if err != nil {
+		logger.Err(err).Msg("invalid LinkLocal")
+		return err
+	}
+
 	addrs, err := netlink.AddrList(link, syscall.AF_INET)
 	if err != nil {
 		logger.Err(err).Msg("cannot read link address")
@@ -272,6 +288,60 @@ func SyncAddress(cfg *Config, link netlink.Link, logger zerolog.Logger) error {
 		}
 		logger.Info().Msg("addr deleted")
 	}
+	return syncLinkLocal(cfg, link, linkLocal, logger)
+}
+
+// syncLinkLocal adds the LinkLocal address, and deletes the one derived from the key if LinkLocal is no longer auto.
+// Other IPv6 addresses, including the one assigned by the kernel, are left alone
+func syncLinkLocal(cfg *Config, link netlink.Link, linkLocal *net.IPNet, logger zerolog.Logger) error {
+	addrs, err := netlink.AddrList(link, syscall.AF_INET6)
+	if err != nil {
+		logger.Err(err).Msg("cannot read link address")
+		return err
+	}
+	present := func(addr net.IPNet) *netlink.Addr {
+		for _, a := range addrs {
+			if a.IPNet.String() == addr.String() {
+				return &a
+			}
+		}
+		return nil
+	}
+
+	if cfg.PrivateKey != nil {
+		derived := LinkLocalFromKey(cfg.PrivateKey.PublicKey())
+		wanted := linkLocal != nil && linkLocal.String() == derived.String() ||
+			slices.ContainsFunc(cfg.Address, func(a net.IPNet) bool { return a.String() == derived.String() })
+		if addr := present(derived); addr != nil && !wanted {
+			logger := logger.With().Str("addr", derived.String()).Logger()
+			if err := netlink.AddrDel(link, addr); err != nil {
+				logger.Err(err).Msg("cannot delete addr")
+				return err
+			}
+			logger.Info().Msg("addr deleted")
+		}
+	}
+
+	if linkLocal == nil {
+		return nil
+	}
+	logger = logger.With().Str("addr", linkLocal.String()).Logger()
+	if present(*linkLocal) != nil {
+		logger.Info().Msg("address present")
+		return nil
+	}
+	// the address is unique by the key or picked for this link, skip DAD so it's usable at once
+	if err := netlink.AddrAdd(link, &netlink.Addr{
+		IPNet: linkLocal,
+		Label: cfg.AddressLabel,
+		Flags: unix.IFA_F_NODAD,
+	}); err != nil {
+		if !errors.Is(err, syscall.EEXIST) {
+			logger.Err(err).Msg("cannot add addr")
+			return err
+		}
+	}
+	logger.Info().Msg("address added")
 	return nil
 }
 